@@ -0,0 +1,258 @@
+package main
+
+import (
+	"github.com/vinhut/posted/models"
+	"go.uber.org/zap"
+
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// importBatchSize is how many posts an import inserts per database
+	// call.
+	importBatchSize = 500
+	// maxImportLine is the longest line an import reads.
+	maxImportLine = 1 << 20
+	// maxImportErrors caps the invalid lines an import reports.
+	maxImportErrors = 100
+)
+
+type importOptions struct {
+	BatchSize int
+	// DryRun validates without writing anything.
+	DryRun bool
+}
+
+// importLineError is a line an import left out and why.
+type importLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importResult counts what an import did with each post it read. In a dry
+// run Imported counts the valid posts, ids that are taken already aren't
+// noticed.
+type importResult struct {
+	DryRun   bool `json:"dry_run"`
+	Read     int  `json:"read"`
+	Imported int  `json:"imported"`
+	// Skipped posts have an id that is taken already.
+	Skipped int `json:"skipped"`
+	Invalid int `json:"invalid"`
+	// Errors holds the first maxImportErrors invalid lines.
+	Errors []importLineError `json:"errors"`
+}
+
+// importPosts reads posts from r, one JSON object per line as exportPosts
+// writes them, and imports the valid ones. Invalid lines are left out and
+// reported. Blank lines are ignored.
+func importPosts(ctx context.Context, r io.Reader, postdb models.PostDatabase, opts importOptions) (importResult, error) {
+
+	result := importResult{DryRun: opts.DryRun, Errors: []importLineError{}}
+	batch := make([]models.Post, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		imported := len(batch)
+		if !opts.DryRun {
+			var err error
+			if imported, err = postdb.Import(ctx, batch); err != nil {
+				return err
+			}
+		}
+		result.Imported += imported
+		result.Skipped += len(batch) - imported
+		batch = batch[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		result.Read++
+
+		post := models.Post{}
+		err := json.Unmarshal(scanner.Bytes(), &post)
+		if err == nil {
+			err = validatePost(&post)
+		}
+		if err != nil {
+			result.Invalid++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, importLineError{Line: line, Error: err.Error()})
+			}
+			continue
+		}
+
+		batch = append(batch, post)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("%w: line %d is longer than %d bytes", errBadRequest, line+1, maxImportLine)
+		}
+		return result, err
+	}
+	return result, flush()
+}
+
+// validatePost checks that an imported post is complete. The rules new
+// posts follow came later than much of the stored data, so they don't
+// apply: posts are imported as they were exported. Only the [""] older
+// versions stored for posts without tags becomes [].
+func validatePost(post *models.Post) error {
+	if len(post.Tag) == 1 && post.Tag[0] == "" {
+		post.Tag = []string{}
+	}
+	errs := &validationError{}
+	if post.Created.IsZero() {
+		errs.add("created", "is required")
+	}
+	if post.Likecount < 0 || post.Commentcount < 0 || post.Viewcount < 0 {
+		errs.add("counts", "must not be negative")
+	}
+	return errs.orNil()
+}
+
+// parsePostFilter reads the uid and date range of an export. Times are
+// RFC 3339 or plain dates, which mean midnight UTC.
+func parsePostFilter(uid, from, to string) (models.PostFilter, error) {
+	filter := models.PostFilter{Uid: uid}
+	var err error
+	if filter.From, err = parseFilterTime("from", from); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseFilterTime("to", to); err != nil {
+		return filter, err
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("%w: from must be before to", errBadRequest)
+	}
+	return filter, nil
+}
+
+func parseFilterTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 time or a YYYY-MM-DD date", errBadRequest, name)
+}
+
+// runExport is the export command: it writes posts as JSON lines to a file
+// or stdout.
+func runExport(ctx context.Context, env *commandEnv, args []string) (err error) {
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	uid := flags.String("uid", "", "only export the posts of this user")
+	from := flags.String("from", "", "only export posts created at or after this time")
+	to := flags.String("to", "", "only export posts created before this time")
+	out := flags.String("out", "-", "file to write, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filter, err := parsePostFilter(*uid, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	w := env.stdout
+	if *out != "-" {
+		var file *os.File
+		file, err = os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if close_err := file.Close(); err == nil {
+				err = close_err
+			}
+		}()
+		w = file
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	exported := 0
+	err = env.postdb.Stream(ctx, filter, func(post models.Post) error {
+		exported++
+		return encoder.Encode(post)
+	})
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	zap.L().Info("posts exported", zap.Int("posts", exported))
+	return nil
+}
+
+// runImport is the import command: it imports posts from a file or stdin
+// and fails when a line is invalid.
+func runImport(ctx context.Context, env *commandEnv, args []string) error {
+
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dry_run := flags.Bool("dry-run", false, "validate the file without writing anything")
+	batch_size := flags.Int("batch-size", importBatchSize, "posts inserted per database call")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("import takes one file, - for stdin")
+	}
+	if *batch_size <= 0 {
+		return errors.New("batch-size must be positive")
+	}
+
+	r := env.stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := importPosts(ctx, r, env.postdb, importOptions{BatchSize: *batch_size, DryRun: *dry_run})
+	for _, line_err := range result.Errors {
+		zap.L().Warn("invalid post", zap.Int("line", line_err.Line), zap.String("error", line_err.Error))
+	}
+	zap.L().Info("posts imported",
+		zap.Bool("dry_run", result.DryRun),
+		zap.Int("read", result.Read),
+		zap.Int("imported", result.Imported),
+		zap.Int("skipped", result.Skipped),
+		zap.Int("invalid", result.Invalid),
+	)
+	if err != nil {
+		return err
+	}
+	if result.Invalid > 0 {
+		return fmt.Errorf("%d invalid posts left out", result.Invalid)
+	}
+	return nil
+}
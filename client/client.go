@@ -0,0 +1,225 @@
+// Package client is a typed Go client for post-service. It hides the token
+// cookie and the result wrapping of the HTTP API and turns error responses
+// into *Error values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinhut/posted/propagation"
+)
+
+const (
+	servicePath     = "/post-service"
+	defaultTimeout  = 10 * time.Second
+	maxErrorBodyLen = 64 << 10
+)
+
+// Post is a post as returned by the service.
+type Post struct {
+	Postid       string
+	Uid          string
+	Username     string
+	Screenname   string
+	Avatarurl    string
+	Verified     bool
+	Imageurl     string
+	Caption      string
+	Likecount    int
+	Private      bool
+	Commentcount int
+	Viewcount    int
+	Created      time.Time
+	Tag          []string
+}
+
+type CreatePostRequest struct {
+	ImgURL  string   `json:"img_url"`
+	Caption string   `json:"post_caption"`
+	Tags    []string `json:"tags"`
+}
+
+// UpdatePostRequest changes the non nil fields of a post.
+type UpdatePostRequest struct {
+	ImgURL  *string   `json:"img_url,omitempty"`
+	Caption *string   `json:"post_caption,omitempty"`
+	Tags    *[]string `json:"tags,omitempty"`
+}
+
+// InternalCreatePostRequest creates a post on behalf of another user, for
+// services that are trusted to name the author.
+type InternalCreatePostRequest struct {
+	CreatePostRequest
+	Uid        string `json:"uid"`
+	Username   string `json:"username"`
+	Screenname string `json:"screenname"`
+	Avatarurl  string `json:"avatarurl"`
+}
+
+// UserUpdatedRequest carries a user's changed profile fields. Nil fields
+// are left as they are in the user's posts.
+type UserUpdatedRequest struct {
+	Uid        string  `json:"uid"`
+	Username   *string `json:"username,omitempty"`
+	Screenname *string `json:"screenname,omitempty"`
+	Avatarurl  *string `json:"avatarurl,omitempty"`
+	Verified   *bool   `json:"verified,omitempty"`
+}
+
+type Client struct {
+	endpoint string
+	client   *http.Client
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default client, which times out after 10s.
+func WithHTTPClient(http_client *http.Client) Option {
+	return func(c *Client) {
+		c.client = http_client
+	}
+}
+
+// New returns a client for the service at base_url, e.g.
+// http://post-service:8080.
+func New(base_url string, opts ...Option) *Client {
+	c := &Client{
+		endpoint: strings.TrimRight(base_url, "/"),
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetPost fetches one post. token is the caller's auth token.
+func (c *Client) GetPost(ctx context.Context, token, postid string) (*Post, error) {
+	query := url.Values{"postid": {postid}}
+	post := &Post{}
+	if err := c.do(ctx, "GET", servicePath+"/post", query, token, nil, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (c *Client) CreatePost(ctx context.Context, token string, req CreatePostRequest) error {
+	return c.do(ctx, "POST", servicePath+"/post", nil, token, req, nil)
+}
+
+func (c *Client) UpdatePost(ctx context.Context, token, postid string, req UpdatePostRequest) error {
+	query := url.Values{"postid": {postid}}
+	return c.do(ctx, "PUT", servicePath+"/post", query, token, req, nil)
+}
+
+func (c *Client) DeletePost(ctx context.Context, token, postid string) error {
+	query := url.Values{"postid": {postid}}
+	return c.do(ctx, "DELETE", servicePath+"/post", query, token, nil, nil)
+}
+
+// ListPosts returns the ids of at most limit posts, in storage order.
+func (c *Client) ListPosts(ctx context.Context, limit int) ([]string, error) {
+	query := url.Values{"range": {strconv.Itoa(limit)}}
+	return c.list(ctx, servicePath+"/allpost", query, "")
+}
+
+// ListUserPosts returns the ids of the posts written by username.
+func (c *Client) ListUserPosts(ctx context.Context, token, username string) ([]string, error) {
+	return c.list(ctx, servicePath+"/user/"+url.PathEscape(username), nil, token)
+}
+
+func (c *Client) InternalCreatePost(ctx context.Context, req InternalCreatePostRequest) error {
+	return c.do(ctx, "POST", "/internal/post", nil, "", req, nil)
+}
+
+// UserUpdated copies a user's new profile into all of their posts and
+// returns how many were updated.
+func (c *Client) UserUpdated(ctx context.Context, req UserUpdatedRequest) (int, error) {
+	var result struct {
+		Updated int `json:"updated"`
+	}
+	if err := c.do(ctx, "POST", "/internal/user-updated", nil, "", req, &result); err != nil {
+		return 0, err
+	}
+	return result.Updated, nil
+}
+
+func (c *Client) list(ctx context.Context, path string, query url.Values, token string) ([]string, error) {
+	var result struct {
+		Results []string `json:"results"`
+	}
+	if err := c.do(ctx, "GET", path, query, token, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Results == nil {
+		result.Results = make([]string, 0)
+	}
+	return result.Results, nil
+}
+
+// do sends body as JSON and decodes a 200 JSON answer into out, when out
+// is not nil. Any other status is decoded into an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, token string, body, out interface{}) (err error) {
+
+	var payload io.Reader
+	if body != nil {
+		data, marshal_err := json.Marshal(body)
+		if marshal_err != nil {
+			return marshal_err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	target := c.endpoint + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+	if request_id := propagation.RequestID(ctx); request_id != "" {
+		req.Header.Set(propagation.RequestIDHeader, request_id)
+	}
+
+	if span := propagation.InjectSpan(req, "post-service "+method+" "+path); span != nil {
+		defer func() {
+			propagation.FinishClientSpan(span, err)
+		}()
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp)
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if decode_err := json.NewDecoder(resp.Body).Decode(out); decode_err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", method, path, decode_err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/propagation"
+)
+
+func TestClientRequests(t *testing.T) {
+
+	var last *http.Request
+	var last_body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		last_body, _ = ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/post-service/post":
+			if r.Method == "GET" {
+				w.Write([]byte(`{"Postid": "1", "Caption": "hello", "Tag": ["go"]}`))
+			}
+		case "/post-service/allpost", "/post-service/user/a b":
+			w.Write([]byte(`{"results": null}`))
+		case "/internal/user-updated":
+			w.Write([]byte(`{"updated": 3}`))
+		}
+	}))
+	defer server.Close()
+	c := New(server.URL + "/")
+
+	ctx := propagation.WithRequestID(context.Background(), "req-1")
+	post, err := c.GetPost(ctx, "token", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Post{Postid: "1", Caption: "hello", Tag: []string{"go"}}, post)
+	assert.Equal(t, "1", last.URL.Query().Get("postid"))
+	assert.Equal(t, "req-1", last.Header.Get(propagation.RequestIDHeader))
+	assert.Equal(t, "application/json", last.Header.Get("Accept"))
+	cookie, _ := last.Cookie("token")
+	if assert.NotNil(t, cookie) {
+		assert.Equal(t, "token", cookie.Value)
+	}
+
+	caption := "edited"
+	assert.NoError(t, c.UpdatePost(context.Background(), "token", "1", UpdatePostRequest{Caption: &caption}))
+	assert.Equal(t, "PUT", last.Method)
+	assert.Equal(t, "application/json", last.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"post_caption": "edited"}`, string(last_body))
+	assert.Equal(t, "", last.Header.Get(propagation.RequestIDHeader))
+
+	// results are never nil
+	postids, err := c.ListPosts(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, postids)
+	assert.Equal(t, "5", last.URL.Query().Get("range"))
+	_, err = c.ListUserPosts(context.Background(), "token", "a b")
+	assert.NoError(t, err)
+
+	// internal calls send no cookie
+	updated, err := c.UserUpdated(context.Background(), UserUpdatedRequest{Uid: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, updated)
+	assert.Empty(t, last.Cookies())
+
+}
+
+func TestClientErrors(t *testing.T) {
+
+	status := 422
+	body := `{"error": {"code": "validation_failed", "message": "invalid post", "request_id": "req-1", "fields": [{"field": "tags", "message": "too many"}]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	c := New(server.URL)
+
+	err := c.CreatePost(context.Background(), "token", CreatePostRequest{})
+	assert.True(t, errors.Is(err, ErrValidation))
+	var api_err *Error
+	if assert.True(t, errors.As(err, &api_err)) {
+		assert.Equal(t, 422, api_err.StatusCode)
+		assert.Equal(t, "req-1", api_err.RequestID)
+		assert.Equal(t, []FieldError{{Field: "tags", Message: "too many"}}, api_err.Fields)
+	}
+	assert.Equal(t, "post-service: Unprocessable Entity: invalid post; tags too many (request req-1)", err.Error())
+
+	// answers without the envelope keep their status and text
+	status = 502
+	body = "bad gateway\n"
+	err = c.DeletePost(context.Background(), "token", "1")
+	assert.True(t, errors.As(err, &api_err))
+	assert.Equal(t, &Error{StatusCode: 502, Message: "bad gateway"}, api_err)
+	assert.Nil(t, errors.Unwrap(err))
+
+	// a 200 that isn't JSON is a decoding error
+	status = 200
+	body = "<html>"
+	_, err = c.GetPost(context.Background(), "token", "1")
+	var syntax_err *json.SyntaxError
+	assert.True(t, errors.As(err, &syntax_err))
+
+}
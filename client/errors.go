@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matched by errors.Is against an *Error, by the code the
+// service answered with.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrValidation      = errors.New("validation failed")
+	ErrAuthUnavailable = errors.New("auth service unavailable")
+)
+
+var errorCodes = map[string]error{
+	"bad_request":       ErrBadRequest,
+	"invalid_id":        ErrBadRequest,
+	"unauthorized":      ErrUnauthorized,
+	"forbidden":         ErrForbidden,
+	"not_found":         ErrNotFound,
+	"conflict":          ErrConflict,
+	"validation_failed": ErrValidation,
+	"auth_unavailable":  ErrAuthUnavailable,
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a non 200 answer from the service.
+type Error struct {
+	StatusCode int
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	RequestID  string       `json:"request_id"`
+	Fields     []FieldError `json:"fields"`
+}
+
+func (err *Error) Error() string {
+	msg := "post-service: " + http.StatusText(err.StatusCode)
+	if err.Message != "" {
+		msg += ": " + err.Message
+	}
+	for _, field := range err.Fields {
+		msg += "; " + field.Field + " " + field.Message
+	}
+	if err.RequestID != "" {
+		msg += " (request " + err.RequestID + ")"
+	}
+	return msg
+}
+
+func (err *Error) Unwrap() error {
+	return errorCodes[err.Code]
+}
+
+// decodeError reads the error envelope of resp. Answers without one, such
+// as those of a proxy in between, keep their status and body text.
+func decodeError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLen))
+
+	var envelope struct {
+		Error *Error `json:"error"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error != nil {
+		envelope.Error.StatusCode = resp.StatusCode
+		return envelope.Error
+	}
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(data)),
+	}
+}
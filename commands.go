@@ -0,0 +1,294 @@
+package main
+
+import (
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/helpers"
+	"github.com/vinhut/posted/models"
+	"github.com/vinhut/posted/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// listCaptionLength is how much of a caption post list shows.
+const listCaptionLength = 40
+
+// command is a subcommand of the binary.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, env *commandEnv, args []string) error
+}
+
+var commands = []command{
+	{"serve", "serve", "run the HTTP and gRPC servers, the default", runServe},
+	{"migrate", "migrate", "apply pending migrations and create missing indexes", runMigrate},
+	{"reindex", "reindex", "replace indexes that changed or are no longer declared", runReindex},
+	{"purge-cache", "purge-cache --all | --user <uid> | --legacy | <postid>...", "drop cached posts", runPurgeCache},
+	{"export", "export [--uid <uid>] [--from <time>] [--to <time>] [--out <file>]", "write posts as JSON lines", runExport},
+	{"import", "import [--dry-run] [--batch-size <n>] <file>", "import posts from JSON lines, - reads stdin", runImport},
+	{"post", "post get <postid> | post delete <postid> | post list --user <uid>", "look at or delete posts", runPost},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [--dev] [command]\n\nCommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.usage, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// commandEnv is what commands run against: Mongo and Redis, or their
+// in-memory stand-ins in dev mode.
+type commandEnv struct {
+	cfg    config.Config
+	mongo  helpers.DatabaseHelper
+	cache  services.RedisService
+	postdb models.PostDatabase
+	posts  *postCache
+	// local is the in-process tier of posts, nil when it is turned off.
+	local  *services.LayeredRedisService
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func newCommandEnv(cfg config.Config, mongo_layer helpers.DatabaseHelper, cache services.RedisService) *commandEnv {
+	env := &commandEnv{
+		cfg:    cfg,
+		mongo:  mongo_layer,
+		cache:  cache,
+		postdb: models.NewPostDatabase(mongo_layer),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+	}
+	post_cache := cache
+	if cfg.PostCache.LocalSize > 0 {
+		env.local = services.NewLayeredRedisService(cache, cfg.PostCache.LocalSize, cfg.PostCache.LocalTTL, cfg.PostCache.InvalidationChannel)
+		post_cache = env.local
+	}
+	env.posts = newPostCache(post_cache, env.postdb, cfg.PostCache)
+	return env
+}
+
+// checkDevCommand rejects dev mode for every command but serve. The others
+// would read or change in-memory stores that start empty and are gone when
+// the command exits.
+func checkDevCommand(cfg config.Config, name string) error {
+	if cfg.Dev && name != "serve" {
+		return fmt.Errorf("--dev only works with serve, %s would run against empty in-memory stores", name)
+	}
+	return nil
+}
+
+func openCommandEnv(cfg config.Config) (*commandEnv, error) {
+	if cfg.Dev {
+		return newCommandEnv(cfg, helpers.NewMemoryDatabase(), services.NewMemoryRedisService()), nil
+	}
+	mongo_layer, err := helpers.NewMongoDatabase(cfg.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	return newCommandEnv(cfg, mongo_layer, services.NewRedisService(cfg.Redis)), nil
+}
+
+func (env *commandEnv) close(ctx context.Context) {
+	if err := env.mongo.Close(ctx); err != nil {
+		zap.L().Error("mongo disconnect", zap.Error(err))
+	}
+	if err := env.cache.Close(); err != nil {
+		zap.L().Error("redis close", zap.Error(err))
+	}
+}
+
+func runMigrate(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := migrate(env.mongo, env.cfg.Mongo.MigrationTimeout); err != nil {
+		return err
+	}
+	zap.L().Info("schema is up to date")
+	return nil
+}
+
+func runReindex(ctx context.Context, env *commandEnv, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, env.cfg.Mongo.MigrationTimeout)
+	defer cancel()
+	if err := env.mongo.Reindex(ctx); err != nil {
+		return err
+	}
+	zap.L().Info("indexes are up to date")
+	return nil
+}
+
+// legacyPostCacheMatch matches the keys posts were cached under before
+// they got postCachePrefix: bare post ids.
+const legacyPostCacheMatch = "????????????????????????"
+
+// runPurgeCache drops the cache entries of the given posts, of every post
+// of a user or of every post. Entries of posts no longer in Mongo can only
+// be dropped by id. --legacy drops the unprefixed entries older versions
+// wrote without a TTL, whether their posts still exist or not.
+func runPurgeCache(ctx context.Context, env *commandEnv, args []string) error {
+
+	flags := flag.NewFlagSet("purge-cache", flag.ContinueOnError)
+	all := flags.Bool("all", false, "drop the entries of all posts")
+	uid := flags.String("user", "", "drop the entries of the posts of this user")
+	legacy := flags.Bool("legacy", false, "drop the entries older versions cached under bare post ids")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	by_id := flags.NArg() > 0
+	chosen := 0
+	for _, set := range []bool{*all, *uid != "", *legacy, by_id} {
+		if set {
+			chosen++
+		}
+	}
+	if chosen != 1 {
+		return errors.New("purge-cache takes one of --all, --user, --legacy or post ids")
+	}
+	if *legacy {
+		return purgeLegacyCache(ctx, env.cache)
+	}
+
+	purged := 0
+	purge := func(postid string) error {
+		if err := env.posts.Invalidate(ctx, postid); err != nil {
+			return fmt.Errorf("post %s: %w", postid, err)
+		}
+		purged++
+		return nil
+	}
+
+	var err error
+	if by_id {
+		for _, postid := range flags.Args() {
+			if err = purge(postid); err != nil {
+				break
+			}
+		}
+	} else {
+		err = env.postdb.Stream(ctx, models.PostFilter{Uid: *uid}, func(post models.Post) error {
+			return purge(post.Postid.Hex())
+		})
+	}
+	zap.L().Info("cache purged", zap.Int("posts", purged))
+	return err
+}
+
+// purgeLegacyCache deletes the keys that are bare post ids.
+func purgeLegacyCache(ctx context.Context, cache services.RedisService) error {
+
+	purged := 0
+	err := cache.Scan(ctx, legacyPostCacheMatch, func(key string) error {
+		if !primitive.IsValidObjectID(key) {
+			return nil
+		}
+		if err := cache.Delete(ctx, key); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+		purged++
+		return nil
+	})
+	zap.L().Info("legacy cache entries purged", zap.Int("keys", purged))
+	return err
+
+}
+
+// runPost is the post command, for looking at and deleting single posts.
+func runPost(ctx context.Context, env *commandEnv, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("post takes get, delete or list")
+	}
+	flags := flag.NewFlagSet("post "+args[0], flag.ContinueOnError)
+	uid := flags.String("user", "", "uid whose posts to list")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "get":
+		if flags.NArg() != 1 {
+			return errors.New("post get takes one post id")
+		}
+		postid := flags.Arg(0)
+		post := models.Post{}
+		if err := env.postdb.Find(ctx, "_id", postid, &post); err != nil {
+			return fmt.Errorf("post %s: %w", postid, err)
+		}
+		encoder := json.NewEncoder(env.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(post)
+
+	case "delete":
+		if flags.NArg() != 1 {
+			return errors.New("post delete takes one post id")
+		}
+		postid := flags.Arg(0)
+		if _, err := env.postdb.Delete(ctx, postid); err != nil {
+			return fmt.Errorf("post %s: %w", postid, err)
+		}
+		if err := env.posts.Invalidate(ctx, postid); err != nil {
+			return fmt.Errorf("post %s is deleted, but still cached: %w", postid, err)
+		}
+		fmt.Fprintln(env.stdout, "deleted", postid)
+		return nil
+
+	case "list":
+		if *uid == "" || flags.NArg() != 0 {
+			return errors.New("post list takes --user")
+		}
+		w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCREATED\tUSERNAME\tCAPTION")
+		err := env.postdb.Stream(ctx, models.PostFilter{Uid: *uid}, func(post models.Post) error {
+			_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				post.Postid.Hex(), post.Created.UTC().Format(time.RFC3339), post.Username, shortCaption(post.Caption))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown post command %q, expected get, delete or list", args[0])
+}
+
+// shortCaption fits a caption on one line of post list.
+func shortCaption(caption string) string {
+	caption = strings.Join(strings.Fields(caption), " ")
+	if runes := []rune(caption); len(runes) > listCaptionLength {
+		return string(runes[:listCaptionLength-3]) + "..."
+	}
+	return caption
+}
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	AuthModeRemote = "remote"
+	AuthModeJWT    = "jwt"
+
+	PublisherRedis  = "redis"
+	PublisherMemory = "memory"
+)
+
+// Config holds every setting the service reads at startup. Values come from
+// the defaults below, then an optional YAML file, then the environment.
+type Config struct {
+	Port int `yaml:"port"`
+	// GRPCPort serves the gRPC API, separately from the HTTP one.
+	GRPCPort int    `yaml:"grpc_port"`
+	LogLevel string `yaml:"log_level"`
+	// ShutdownTimeout bounds how long in-flight requests may drain on
+	// SIGTERM before the server is closed anyway.
+	ShutdownTimeout time.Duration   `yaml:"shutdown_timeout"`
+	Mongo           MongoConfig     `yaml:"mongo"`
+	Redis           RedisConfig     `yaml:"redis"`
+	PostCache       PostCacheConfig `yaml:"post_cache"`
+	Auth            AuthConfig      `yaml:"auth"`
+	Jaeger          JaegerConfig    `yaml:"jaeger"`
+	Events          EventsConfig    `yaml:"events"`
+	// Dev replaces Mongo, Redis and the auth service with in-memory fakes,
+	// so their settings aren't required.
+	Dev bool `yaml:"-"`
+}
+
+type MongoConfig struct {
+	URL            string        `yaml:"url"`
+	Database       string        `yaml:"database"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// MigrateOnStartup applies pending migrations and ensures the indexes
+	// before serving. Turn it off to run them as a deploy step with
+	// `posted migrate` instead.
+	MigrateOnStartup bool          `yaml:"migrate_on_startup"`
+	MigrationTimeout time.Duration `yaml:"migration_timeout"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// PostCacheConfig controls how long GET post keeps posts in Redis and in
+// the process.
+type PostCacheConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+	// Jitter adds up to this much to each TTL, so posts cached together
+	// don't expire together.
+	Jitter time.Duration `yaml:"jitter"`
+	// NegativeTTL caches that a post doesn't exist, 0 turns it off. A post
+	// imported under such an id shows up once it runs out.
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	// StaleTTL keeps serving an expired post this long while it is read
+	// again in the background, 0 turns it off.
+	StaleTTL time.Duration `yaml:"stale_ttl"`
+	// LoadTimeout bounds a database read of a post. The read is shared by
+	// every request waiting for the post, so it doesn't end with any one
+	// of them.
+	LoadTimeout time.Duration `yaml:"load_timeout"`
+	// LocalSize keeps up to this many posts in the process, in front of
+	// Redis, 0 turns it off.
+	LocalSize int `yaml:"local_size"`
+	// LocalTTL bounds how long the process keeps a post. Edits and deletes
+	// evict it on every instance, one that misses the eviction serves the
+	// old post this long.
+	LocalTTL time.Duration `yaml:"local_ttl"`
+	// InvalidationChannel is the Redis pub/sub channel evictions go
+	// through.
+	InvalidationChannel string `yaml:"invalidation_channel"`
+}
+
+type AuthConfig struct {
+	// Mode is "remote" to ask the auth service on every request (with a
+	// cache in front) or "jwt" to verify signed tokens locally.
+	Mode             string        `yaml:"mode"`
+	ServiceURL       string        `yaml:"service_url"`
+	Timeout          time.Duration `yaml:"timeout"`
+	MaxRetries       int           `yaml:"max_retries"`
+	RetryBackoff     time.Duration `yaml:"retry_backoff"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	CacheTTL         time.Duration `yaml:"cache_ttl"`
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl"`
+	// CacheInvalidationChannel is the Redis pub/sub channel revoked tokens
+	// are evicted through on every instance.
+	CacheInvalidationChannel string `yaml:"cache_invalidation_channel"`
+	// ServiceToken is what other services authenticate with where they act
+	// for any user or on whole accounts. Without it those calls are
+	// refused.
+	ServiceToken string    `yaml:"service_token"`
+	JWT          JWTConfig `yaml:"jwt"`
+}
+
+type JWTConfig struct {
+	HMACSecret string `yaml:"hmac_secret"`
+	PublicKey  string `yaml:"public_key"`
+	JWKSFile   string `yaml:"jwks_file"`
+}
+
+type JaegerConfig struct {
+	CollectorEndpoint string `yaml:"collector_endpoint"`
+}
+
+type EventsConfig struct {
+	// Publisher is "redis" to append events to a Redis stream on the cache
+	// server or "memory" to keep them in the process.
+	Publisher     string        `yaml:"publisher"`
+	Stream        string        `yaml:"stream"`
+	StreamMaxLen  int           `yaml:"stream_max_len"`
+	RelayInterval time.Duration `yaml:"relay_interval"`
+	BatchSize     int           `yaml:"batch_size"`
+}
+
+func Default() Config {
+	return Config{
+		Port:            8080,
+		GRPCPort:        50051,
+		LogLevel:        "info",
+		ShutdownTimeout: 15 * time.Second,
+		Mongo: MongoConfig{
+			ConnectTimeout:   30 * time.Second,
+			MigrateOnStartup: true,
+			MigrationTimeout: 10 * time.Minute,
+		},
+		PostCache: PostCacheConfig{
+			TTL:                 10 * time.Minute,
+			Jitter:              time.Minute,
+			NegativeTTL:         30 * time.Second,
+			LoadTimeout:         5 * time.Second,
+			LocalSize:           10000,
+			LocalTTL:            10 * time.Second,
+			InvalidationChannel: "post-cache-invalidations",
+		},
+		Auth: AuthConfig{
+			Mode:                     AuthModeRemote,
+			Timeout:                  3 * time.Second,
+			MaxRetries:               2,
+			RetryBackoff:             100 * time.Millisecond,
+			BreakerThreshold:         5,
+			BreakerCooldown:          10 * time.Second,
+			CacheTTL:                 30 * time.Second,
+			NegativeCacheTTL:         5 * time.Second,
+			CacheInvalidationChannel: "auth-cache-invalidations",
+		},
+		Events: EventsConfig{
+			Publisher:     PublisherRedis,
+			Stream:        "post-events",
+			StreamMaxLen:  100000,
+			RelayInterval: time.Second,
+			BatchSize:     100,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file at path (if
+// path is not empty) and the environment, and validates the result.
+func Load(path string) (Config, error) {
+	return load(path, false)
+}
+
+// LoadDev is Load for --dev runs.
+func LoadDev(path string) (Config, error) {
+	return load(path, true)
+}
+
+func load(path string, dev bool) (Config, error) {
+	cfg := Default()
+	cfg.Dev = dev
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func (cfg *Config) applyEnv() error {
+	var errs []string
+
+	setString := func(name string, dst *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = value
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, name+" must be an integer")
+				return
+			}
+			*dst = parsed
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, name+" must be true or false")
+				return
+			}
+			*dst = parsed
+		}
+	}
+	setDuration := func(name string, dst *time.Duration) {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, name+" must be a duration like 5s")
+				return
+			}
+			*dst = parsed
+		}
+	}
+
+	setInt("PORT", &cfg.Port)
+	setInt("GRPC_PORT", &cfg.GRPCPort)
+	setString("LOG_LEVEL", &cfg.LogLevel)
+	setDuration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+
+	setString("MONGO_URL", &cfg.Mongo.URL)
+	setString("MONGO_DATABASE", &cfg.Mongo.Database)
+	setDuration("MONGO_CONNECT_TIMEOUT", &cfg.Mongo.ConnectTimeout)
+	setBool("MONGO_MIGRATE_ON_STARTUP", &cfg.Mongo.MigrateOnStartup)
+	setDuration("MONGO_MIGRATION_TIMEOUT", &cfg.Mongo.MigrationTimeout)
+
+	setString("REDIS_URL", &cfg.Redis.Addr)
+	setString("REDIS_PASSWORD", &cfg.Redis.Password)
+	setInt("REDIS_DB", &cfg.Redis.DB)
+
+	setDuration("POST_CACHE_TTL", &cfg.PostCache.TTL)
+	setDuration("POST_CACHE_JITTER", &cfg.PostCache.Jitter)
+	setDuration("POST_CACHE_NEGATIVE_TTL", &cfg.PostCache.NegativeTTL)
+	setDuration("POST_CACHE_STALE_TTL", &cfg.PostCache.StaleTTL)
+	setDuration("POST_CACHE_LOAD_TIMEOUT", &cfg.PostCache.LoadTimeout)
+	setInt("POST_CACHE_LOCAL_SIZE", &cfg.PostCache.LocalSize)
+	setDuration("POST_CACHE_LOCAL_TTL", &cfg.PostCache.LocalTTL)
+	setString("POST_CACHE_INVALIDATION_CHANNEL", &cfg.PostCache.InvalidationChannel)
+
+	setString("AUTH_MODE", &cfg.Auth.Mode)
+	setString("AUTH_SERVICE_URL", &cfg.Auth.ServiceURL)
+	setDuration("AUTH_TIMEOUT", &cfg.Auth.Timeout)
+	setInt("AUTH_MAX_RETRIES", &cfg.Auth.MaxRetries)
+	setDuration("AUTH_RETRY_BACKOFF", &cfg.Auth.RetryBackoff)
+	setInt("AUTH_BREAKER_THRESHOLD", &cfg.Auth.BreakerThreshold)
+	setDuration("AUTH_BREAKER_COOLDOWN", &cfg.Auth.BreakerCooldown)
+	setDuration("AUTH_CACHE_TTL", &cfg.Auth.CacheTTL)
+	setDuration("AUTH_NEGATIVE_CACHE_TTL", &cfg.Auth.NegativeCacheTTL)
+	setString("AUTH_CACHE_INVALIDATION_CHANNEL", &cfg.Auth.CacheInvalidationChannel)
+	setString("AUTH_SERVICE_TOKEN", &cfg.Auth.ServiceToken)
+	setString("JWT_HMAC_SECRET", &cfg.Auth.JWT.HMACSecret)
+	setString("JWT_PUBLIC_KEY", &cfg.Auth.JWT.PublicKey)
+	setString("JWT_JWKS_FILE", &cfg.Auth.JWT.JWKSFile)
+
+	setString("JAEGER_COLLECTOR_ENDPOINT", &cfg.Jaeger.CollectorEndpoint)
+
+	setString("EVENTS_PUBLISHER", &cfg.Events.Publisher)
+	setString("EVENTS_STREAM", &cfg.Events.Stream)
+	setInt("EVENTS_STREAM_MAX_LEN", &cfg.Events.StreamMaxLen)
+	setDuration("EVENTS_RELAY_INTERVAL", &cfg.Events.RelayInterval)
+	setInt("EVENTS_BATCH_SIZE", &cfg.Events.BatchSize)
+
+	if len(errs) > 0 {
+		return errors.New("invalid environment: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Validate reports every missing or malformed setting at once so a bad
+// deployment fails on the first start instead of one value at a time.
+func (cfg Config) Validate() error {
+	var errs []string
+	require := func(ok bool, msg string) {
+		if !ok {
+			errs = append(errs, msg)
+		}
+	}
+
+	require(cfg.Port > 0 && cfg.Port < 65536, "port must be between 1 and 65535")
+	require(cfg.GRPCPort > 0 && cfg.GRPCPort < 65536, "grpc port must be between 1 and 65535")
+	require(cfg.GRPCPort != cfg.Port, "grpc port must differ from the http port")
+	require(cfg.ShutdownTimeout > 0, "shutdown timeout must be positive")
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, "log level must be debug, info, warn or error")
+	}
+	if !cfg.Dev {
+		require(cfg.Mongo.URL != "", "mongo url is required (MONGO_URL)")
+		require(cfg.Mongo.Database != "", "mongo database is required (MONGO_DATABASE)")
+		require(cfg.Mongo.ConnectTimeout > 0, "mongo connect timeout must be positive")
+		require(cfg.Mongo.MigrationTimeout > 0, "mongo migration timeout must be positive")
+		require(cfg.Redis.Addr != "", "redis address is required (REDIS_URL)")
+		require(cfg.Redis.DB >= 0, "redis db must not be negative")
+
+		switch cfg.Auth.Mode {
+		case AuthModeRemote:
+			require(cfg.Auth.ServiceURL != "", "auth service url is required (AUTH_SERVICE_URL)")
+			require(cfg.Auth.CacheInvalidationChannel != "", "auth cache invalidation channel is required (AUTH_CACHE_INVALIDATION_CHANNEL)")
+		case AuthModeJWT:
+			jwt := cfg.Auth.JWT
+			require(jwt.HMACSecret != "" || jwt.PublicKey != "" || jwt.JWKSFile != "",
+				"jwt auth mode needs JWT_HMAC_SECRET, JWT_PUBLIC_KEY or JWT_JWKS_FILE")
+		default:
+			errs = append(errs, "auth mode must be "+AuthModeRemote+" or "+AuthModeJWT)
+		}
+		require(cfg.Auth.Timeout > 0, "auth timeout must be positive")
+		require(cfg.Auth.MaxRetries >= 0, "auth max retries must not be negative")
+		require(cfg.Auth.BreakerThreshold > 0, "auth breaker threshold must be positive")
+		require(cfg.Auth.CacheTTL > 0 && cfg.Auth.NegativeCacheTTL > 0, "auth cache ttls must be positive")
+	}
+
+	require(cfg.PostCache.TTL > 0, "post cache ttl must be positive")
+	require(cfg.PostCache.LoadTimeout > 0, "post cache load timeout must be positive")
+	require(cfg.PostCache.Jitter >= 0 && cfg.PostCache.NegativeTTL >= 0 && cfg.PostCache.StaleTTL >= 0,
+		"post cache jitter, negative ttl and stale ttl must not be negative")
+	require(cfg.PostCache.LocalSize >= 0, "post cache local size must not be negative")
+	if cfg.PostCache.LocalSize > 0 {
+		require(cfg.PostCache.LocalTTL > 0, "post cache local ttl must be positive")
+		require(cfg.PostCache.InvalidationChannel != "", "post cache invalidation channel is required (POST_CACHE_INVALIDATION_CHANNEL)")
+	}
+
+	switch cfg.Events.Publisher {
+	case PublisherRedis:
+		require(cfg.Events.Stream != "", "events stream is required (EVENTS_STREAM)")
+		require(cfg.Events.StreamMaxLen > 0, "events stream max len must be positive")
+	case PublisherMemory:
+	default:
+		errs = append(errs, "events publisher must be "+PublisherRedis+" or "+PublisherMemory)
+	}
+	require(cfg.Events.RelayInterval > 0, "events relay interval must be positive")
+	require(cfg.Events.BatchSize > 0, "events batch size must be positive")
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Addr is the listen address for the HTTP server.
+func (cfg Config) Addr() string {
+	return ":" + strconv.Itoa(cfg.Port)
+}
+
+// GRPCAddr is the listen address for the gRPC server.
+func (cfg Config) GRPCAddr() string {
+	return ":" + strconv.Itoa(cfg.GRPCPort)
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+
+	config_file, _ := ioutil.TempFile("", "post-service-*.yaml")
+	defer os.Remove(config_file.Name())
+	config_file.WriteString(`
+port: 9090
+mongo:
+  url: mongodb://localhost:27017
+  database: posts
+redis:
+  addr: localhost:6379
+  db: 2
+auth:
+  service_url: http://auth
+  cache_ttl: 1m
+post_cache:
+  ttl: 5m
+  stale_ttl: 1h
+`)
+	config_file.Close()
+
+	os.Setenv("REDIS_PASSWORD", "secret")
+	defer os.Unsetenv("REDIS_PASSWORD")
+
+	cfg, err := Load(config_file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, ":9090", cfg.Addr())
+	assert.Equal(t, "posts", cfg.Mongo.Database)
+	assert.Equal(t, 2, cfg.Redis.DB)
+	assert.Equal(t, "secret", cfg.Redis.Password)
+	assert.Equal(t, time.Minute, cfg.Auth.CacheTTL)
+	assert.Equal(t, Default().Auth.Timeout, cfg.Auth.Timeout)
+	assert.True(t, cfg.Mongo.MigrateOnStartup)
+	assert.Equal(t, 5*time.Minute, cfg.PostCache.TTL)
+	assert.Equal(t, time.Hour, cfg.PostCache.StaleTTL)
+	assert.Equal(t, Default().PostCache.NegativeTTL, cfg.PostCache.NegativeTTL)
+
+	os.Setenv("POST_CACHE_TTL", "0s")
+	_, ttl_err := Load(config_file.Name())
+	os.Unsetenv("POST_CACHE_TTL")
+	assert.Contains(t, ttl_err.Error(), "post cache ttl must be positive")
+
+	os.Setenv("POST_CACHE_LOAD_TIMEOUT", "0s")
+	_, ttl_err = Load(config_file.Name())
+	os.Unsetenv("POST_CACHE_LOAD_TIMEOUT")
+	assert.Contains(t, ttl_err.Error(), "post cache load timeout must be positive")
+
+	os.Setenv("POST_CACHE_LOCAL_TTL", "0s")
+	_, ttl_err = Load(config_file.Name())
+	os.Unsetenv("POST_CACHE_LOCAL_TTL")
+	assert.Contains(t, ttl_err.Error(), "post cache local ttl must be positive")
+
+	os.Setenv("MONGO_MIGRATE_ON_STARTUP", "false")
+	defer os.Unsetenv("MONGO_MIGRATE_ON_STARTUP")
+	cfg, _ = Load(config_file.Name())
+	assert.False(t, cfg.Mongo.MigrateOnStartup)
+
+	os.Setenv("AUTH_MODE", "jwt")
+	defer os.Unsetenv("AUTH_MODE")
+	_, jwt_err := Load(config_file.Name())
+	assert.Contains(t, jwt_err.Error(), "jwt auth mode needs")
+
+	os.Setenv("REDIS_DB", "two")
+	defer os.Unsetenv("REDIS_DB")
+	_, env_err := Load(config_file.Name())
+	assert.Contains(t, env_err.Error(), "REDIS_DB must be an integer")
+
+	os.Setenv("MONGO_MIGRATE_ON_STARTUP", "sometimes")
+	_, env_err = Load(config_file.Name())
+	assert.Contains(t, env_err.Error(), "MONGO_MIGRATE_ON_STARTUP must be true or false")
+
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"github.com/vinhut/posted/logging"
+	"github.com/vinhut/posted/models"
+	"go.uber.org/zap"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// userDeletionBatchSize is how many posts a deletion job removes per
+// transaction.
+const userDeletionBatchSize = 100
+
+// userDeletionRunner runs account deletion jobs in the background. A job
+// runs on the instance that claims it; the others leave it alone until
+// its lease runs out. Jobs stop between batches on shutdown and are
+// picked up again by Resume.
+type userDeletionRunner struct {
+	postdb models.PostDatabase
+	jobs   models.UserDeletions
+	posts  *postCache
+	// owner names this instance in the jobs it claims
+	owner string
+
+	ctx     context.Context
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]*models.UserDeletion
+}
+
+// newUserDeletionRunner runs jobs until ctx is done.
+func newUserDeletionRunner(ctx context.Context, postdb models.PostDatabase, jobs models.UserDeletions, posts *postCache) *userDeletionRunner {
+	return &userDeletionRunner{
+		postdb:  postdb,
+		jobs:    jobs,
+		posts:   posts,
+		owner:   newRunnerID(),
+		ctx:     ctx,
+		running: make(map[string]*models.UserDeletion),
+	}
+}
+
+// newRunnerID tells instances apart, the hostname alone may be reused by
+// the next pod.
+func newRunnerID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Start stores a job for uid and runs it. An unfinished job for uid, here
+// or on another instance, is returned instead of starting another one.
+func (runner *userDeletionRunner) Start(ctx context.Context, uid, mode string) (models.UserDeletion, error) {
+	if job, running := runner.current(uid); running {
+		return job, nil
+	}
+
+	// Create fails for a uid with an unfinished job, so concurrent calls
+	// can't start two of them.
+	job, err := runner.jobs.Create(ctx, uid, mode)
+	if errors.Is(err, models.ErrConflict) {
+		job, err = runner.jobs.Latest(ctx, uid)
+		if err != nil {
+			return models.UserDeletion{}, err
+		}
+		return *job, nil
+	}
+	if err != nil {
+		return models.UserDeletion{}, err
+	}
+	return runner.claim(ctx, job)
+}
+
+// current returns a copy of the job running here for uid.
+func (runner *userDeletionRunner) current(uid string) (models.UserDeletion, bool) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	job, running := runner.running[uid]
+	if !running {
+		return models.UserDeletion{}, false
+	}
+	return *job, true
+}
+
+// Status returns the newest job for uid, with live progress if it runs
+// here.
+func (runner *userDeletionRunner) Status(ctx context.Context, uid string) (models.UserDeletion, error) {
+	if current, running := runner.current(uid); running {
+		return current, nil
+	}
+
+	stored, err := runner.jobs.Latest(ctx, uid)
+	if err != nil {
+		return models.UserDeletion{}, err
+	}
+	return *stored, nil
+}
+
+// Resume runs the unfinished jobs no other instance holds: the ones a
+// previous run released on shutdown and the ones whose lease ran out.
+func (runner *userDeletionRunner) Resume(ctx context.Context) error {
+	unfinished, err := runner.jobs.Unfinished(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range unfinished {
+		job := &unfinished[i]
+		if _, running := runner.current(job.Uid); running {
+			continue
+		}
+		if _, err := runner.claim(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResumeEvery calls Resume every interval until the runner's context is
+// done, to take over the jobs of instances that went away.
+func (runner *userDeletionRunner) ResumeEvery(interval time.Duration) {
+	runner.wg.Add(1)
+	go func() {
+		defer runner.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runner.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := runner.Resume(runner.ctx); err != nil && runner.ctx.Err() == nil {
+				zap.L().Warn("resuming user deletions", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// claim runs job if this instance wins it and returns it as it was
+// launched. Only one instance wins a claim, so the job runs once. It logs
+// with the request and trace ids of ctx.
+func (runner *userDeletionRunner) claim(ctx context.Context, job *models.UserDeletion) (models.UserDeletion, error) {
+	processed := job.Processed
+	claimed, err := runner.jobs.Claim(ctx, job, runner.owner)
+	if err != nil {
+		return models.UserDeletion{}, err
+	}
+	if !claimed {
+		return *job, nil
+	}
+	logger := logging.FromContext(ctx).With(zap.String("uid", job.Uid), zap.String("job", job.ID.Hex()))
+	if processed > 0 {
+		logger.Info("resuming user deletion", zap.Int("processed", processed))
+	}
+	return runner.launch(job, logger), nil
+}
+
+// Wait blocks until every job has stopped.
+func (runner *userDeletionRunner) Wait() {
+	runner.wg.Wait()
+}
+
+// launch runs job in the background and returns a copy of it from before
+// it started.
+func (runner *userDeletionRunner) launch(job *models.UserDeletion, logger *zap.Logger) models.UserDeletion {
+	runner.mu.Lock()
+	runner.running[job.Uid] = job
+	launched := *job
+	runner.mu.Unlock()
+	runner.wg.Add(1)
+	go func() {
+		defer runner.wg.Done()
+		runner.run(job, logger)
+		runner.mu.Lock()
+		delete(runner.running, job.Uid)
+		runner.mu.Unlock()
+	}()
+	return launched
+}
+
+func (runner *userDeletionRunner) run(job *models.UserDeletion, logger *zap.Logger) {
+
+	ctx := runner.ctx
+
+	remove := runner.postdb.DeleteByUser
+	if job.Mode == models.DeletionModeAnonymize {
+		remove = runner.postdb.AnonymizeByUser
+	}
+
+	_, err := remove(ctx, job.Uid, userDeletionBatchSize, func(postids []string) error {
+		// A failed invalidation must not stop the job: the posts are gone
+		// already and wouldn't be found again on a retry. Their cache
+		// entries run out with their TTL.
+		for _, postid := range postids {
+			if cache_err := runner.posts.Invalidate(ctx, postid); cache_err != nil {
+				logger.Warn("invalidating deleted post", zap.String("postid", postid), zap.Error(cache_err))
+			}
+		}
+		return runner.update(job, logger, func() { job.Processed += len(postids) })
+	})
+
+	if errors.Is(err, models.ErrLeaseLost) {
+		logger.Warn("user deletion taken over by another instance", zap.Int("processed", job.Processed))
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		logger.Info("user deletion interrupted, releasing it", zap.Int("processed", job.Processed))
+		runner.mu.Lock()
+		released := *job
+		runner.mu.Unlock()
+		if release_err := runner.jobs.Release(context.Background(), &released); release_err != nil {
+			logger.Warn("releasing user deletion", zap.Error(release_err))
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("user deletion failed", zap.Error(err))
+		runner.update(job, logger, func() {
+			job.Status = models.DeletionFailed
+			job.Error = err.Error()
+		})
+		return
+	}
+	logger.Info("user deletion done", zap.Int("processed", job.Processed))
+	runner.update(job, logger, func() { job.Status = models.DeletionDone })
+}
+
+// update changes job under the lock Status reads it with and stores it.
+// Saving is retried on the next update, so a failure is only logged,
+// unless the job was lost to another instance: that is returned.
+func (runner *userDeletionRunner) update(job *models.UserDeletion, logger *zap.Logger, change func()) error {
+	runner.mu.Lock()
+	change()
+	saved := *job
+	runner.mu.Unlock()
+
+	// the job state is saved even when shutdown interrupts the run
+	err := runner.jobs.Save(context.Background(), &saved)
+	if errors.Is(err, models.ErrLeaseLost) {
+		return err
+	}
+	if err != nil {
+		logger.Warn("saving user deletion progress", zap.Error(err))
+	}
+	runner.mu.Lock()
+	job.Updated = saved.Updated
+	job.Active = saved.Active
+	job.LeaseUntil = saved.LeaseUntil
+	runner.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/vinhut/posted/services"
+)
+
+// devToken is accepted as the token cookie in --dev mode, for devUser.
+const devToken = "dev-token"
+
+// devServiceToken is the service token in --dev mode.
+const devServiceToken = "dev-service-token"
+
+var devUser = services.FakeUser{
+	Uid:        "dev",
+	Email:      "dev@localhost",
+	Role:       "standard",
+	Username:   "dev",
+	Screenname: "Dev",
+	Verified:   "true",
+}
+
+// nopCloser stands in for the tracer in --dev mode, which has none.
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vinhut/posted/models"
+	"github.com/vinhut/posted/propagation"
+	"github.com/vinhut/posted/services"
+
+	"errors"
+)
+
+var (
+	errBadRequest     = errors.New("bad request")
+	errRouteNotFound  = errors.New("route not found")
+	errNotImplemented = errors.New("not implemented")
+)
+
+// apiError is the body of every error response:
+// { "error": { "code": ..., "message": ..., "request_id": ... } }
+// Validation failures also list the offending fields.
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id"`
+	Fields    []fieldError `json:"fields,omitempty"`
+}
+
+// errorStatus maps a domain error onto an HTTP status and a stable code
+// clients can switch on. Unknown errors are 500s and their message is not
+// shown to the caller.
+func errorStatus(err error) (int, string, string) {
+	var validation_err *validationError
+	switch {
+	case errors.As(err, &validation_err):
+		return 422, "validation_failed", "validation failed"
+	case errors.Is(err, errBadRequest):
+		return 400, "bad_request", err.Error()
+	case errors.Is(err, models.ErrInvalidID):
+		return 400, "invalid_id", err.Error()
+	case errors.Is(err, services.ErrUnauthorized):
+		return 401, "unauthorized", "unauthorized"
+	case errors.Is(err, models.ErrForbidden):
+		return 403, "forbidden", err.Error()
+	case errors.Is(err, models.ErrNotFound), errors.Is(err, errRouteNotFound):
+		return 404, "not_found", err.Error()
+	case errors.Is(err, models.ErrConflict):
+		return 409, "conflict", err.Error()
+	case errors.Is(err, errNotImplemented):
+		return 501, "not_implemented", err.Error()
+	case errors.Is(err, services.ErrAuthUnavailable):
+		return 503, "auth_unavailable", "auth service unavailable"
+	default:
+		return 500, "internal", "internal server error"
+	}
+}
+
+// abortWithError stops the handler chain and leaves the response to
+// errorMiddleware.
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+// errorMiddleware writes the error envelope for the last error a handler
+// recorded, unless the handler already answered.
+func errorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		status, code, message := errorStatus(err)
+		api_err := apiError{
+			Code:      code,
+			Message:   message,
+			RequestID: propagation.RequestID(c.Request.Context()),
+		}
+		var validation_err *validationError
+		if errors.As(err, &validation_err) {
+			api_err.Fields = validation_err.Fields
+		}
+		c.JSON(status, gin.H{"error": api_err})
+	}
+}
@@ -0,0 +1,51 @@
+// Package events carries the domain events post-service emits. Writes put
+// their events in an outbox in the same transaction; a Relay moves them from
+// the outbox to an EventPublisher. Delivery is at least once, so consumers
+// should drop events whose ID they have already seen.
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PostCreated = "PostCreated"
+	PostUpdated = "PostUpdated"
+	PostDeleted = "PostDeleted"
+)
+
+// Event is one change to a post. Data holds the fields that were written,
+// keyed as they are stored; PostDeleted events have none.
+type Event struct {
+	ID      primitive.ObjectID     `bson:"_id" json:"id"`
+	Type    string                 `bson:"type" json:"type"`
+	PostID  string                 `bson:"post_id" json:"post_id"`
+	Data    map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	Created time.Time              `bson:"created" json:"created"`
+}
+
+func New(event_type, post_id string, data map[string]interface{}) Event {
+	return Event{
+		ID:      primitive.NewObjectID(),
+		Type:    event_type,
+		PostID:  post_id,
+		Data:    data,
+		Created: time.Now().UTC(),
+	}
+}
+
+type EventPublisher interface {
+	Publish(context.Context, Event) error
+	Close() error
+}
+
+// Outbox is where events wait until they are published.
+type Outbox interface {
+	// Pending returns up to limit unpublished events, oldest first.
+	Pending(context.Context, int) ([]Event, error)
+	// Ack removes a published event.
+	Ack(context.Context, Event) error
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher keeps published events in memory, for tests and local
+// runs without Redis.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (publisher *MemoryPublisher) Publish(ctx context.Context, event Event) error {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	publisher.events = append(publisher.events, event)
+	return nil
+}
+
+// Events returns a copy of everything published so far, in order.
+func (publisher *MemoryPublisher) Events() []Event {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	return append([]Event(nil), publisher.events...)
+}
+
+func (publisher *MemoryPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "events_published_total",
+		Help:      "Outbox events handed to the publisher, by event type.",
+	}, []string{"type"})
+
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "events_publish_failures_total",
+		Help:      "Outbox events the publisher refused, by event type.",
+	}, []string{"type"})
+
+	outboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "post_service",
+		Name:      "outbox_pending_events",
+		Help:      "Events found waiting in the outbox on the last poll, capped at the batch size.",
+	})
+)
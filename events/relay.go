@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Relay polls the outbox and publishes what it finds, in order. An event is
+// acked only after it was published, so a crash in between publishes it
+// again; that is the at least once part.
+type Relay struct {
+	outbox    Outbox
+	publisher EventPublisher
+	interval  time.Duration
+	batch     int
+}
+
+func NewRelay(outbox Outbox, publisher EventPublisher, interval time.Duration, batch int) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		interval:  interval,
+		batch:     batch,
+	}
+}
+
+// Run relays until ctx is done.
+func (relay *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relay.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			relayed, err := relay.RelayOnce(ctx)
+			if err != nil {
+				zap.L().Warn("relaying outbox events failed", zap.Error(err))
+			}
+			// A full batch means there is likely more waiting.
+			if err != nil || relayed < relay.batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes one batch of pending events and returns how many
+// went out. It stops at the first failure so events keep their order.
+func (relay *Relay) RelayOnce(ctx context.Context) (int, error) {
+	pending, err := relay.outbox.Pending(ctx, relay.batch)
+	if err != nil {
+		return 0, err
+	}
+	outboxPending.Set(float64(len(pending)))
+
+	for i, event := range pending {
+		if err := relay.publisher.Publish(ctx, event); err != nil {
+			publishFailures.WithLabelValues(event.Type).Inc()
+			return i, err
+		}
+		eventsPublished.WithLabelValues(event.Type).Inc()
+		if err := relay.outbox.Ack(ctx, event); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(pending), nil
+}
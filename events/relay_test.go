@@ -0,0 +1,74 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/events"
+	"github.com/vinhut/posted/helpers"
+	mocks_helpers "github.com/vinhut/posted/mocks_helpers"
+	"github.com/vinhut/posted/models"
+)
+
+type failingPublisher struct {
+	events.MemoryPublisher
+	fail_after int
+}
+
+func (publisher *failingPublisher) Publish(ctx context.Context, event events.Event) error {
+	if len(publisher.Events()) >= publisher.fail_after {
+		return errors.New("stream unavailable")
+	}
+	return publisher.MemoryPublisher.Publish(ctx, event)
+}
+func TestOutboxRelay(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock_db := mocks_helpers.NewMockDatabaseHelper(ctrl)
+
+	first := events.New(events.PostCreated, "5f8f8c44b54764421b7156c3", nil)
+	second := events.New(events.PostDeleted, "5f8f8c44b54764421b7156c3", nil)
+	third := events.New(events.PostCreated, "5f8f8c44b54764421b7156c4", nil)
+
+	oldest_first := helpers.NewQuery().Sort("_id", helpers.Ascending).Limit(10)
+	mock_db.EXPECT().Find(gomock.Any(), "outbox", oldest_first, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, collection string, query helpers.Query, results interface{}) error {
+			*results.(*[]events.Event) = []events.Event{first, second, third}
+			return nil
+		}).Times(2)
+	// a real outbox would not hand out acked events again, this one does to
+	// show that acking twice is harmless
+	for _, event := range []events.Event{first, second} {
+		gomock.InOrder(
+			mock_db.EXPECT().Delete(gomock.Any(), "outbox", event.ID.Hex()).Return(nil),
+			mock_db.EXPECT().Delete(gomock.Any(), "outbox", event.ID.Hex()).Return(models.ErrNotFound),
+		)
+	}
+	mock_db.EXPECT().Delete(gomock.Any(), "outbox", third.ID.Hex()).Return(nil)
+
+	publisher := &failingPublisher{fail_after: 2}
+	relay := events.NewRelay(models.NewOutbox(mock_db), publisher, time.Second, 10)
+
+	relayed, err := relay.RelayOnce(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 2, relayed)
+
+	// the failed event goes out on the next poll
+	publisher.fail_after = 10
+	relayed, err = relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, relayed)
+
+	published := publisher.Events()
+	ids := make([]string, len(published))
+	for i, event := range published {
+		ids[i] = event.ID.Hex()
+	}
+	assert.Equal(t, []string{first.ID.Hex(), second.ID.Hex(), first.ID.Hex(), second.ID.Hex(), third.ID.Hex()}, ids)
+
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vinhut/posted/logging"
+	"github.com/vinhut/posted/models"
+	"go.uber.org/zap"
+
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+)
+
+const (
+	exportJSONL = "jsonl"
+	exportZIP   = "zip"
+
+	ndjsonContentType = "application/x-ndjson"
+	zipContentType    = "application/zip"
+)
+
+// exportPosts writes every post matching filter to the response, one JSON
+// object per line, as is or zipped into posts.jsonl. The download is named
+// name plus the format. Posts are streamed as they are read. The response
+// starts with the first post, so a failure before it can still be answered
+// with an error.
+func exportPosts(ctx context.Context, c *gin.Context, postdb models.PostDatabase, filter models.PostFilter, name, format string) error {
+
+	var archive *zip.Writer
+	var encoder *json.Encoder
+	begin := func() error {
+		if encoder != nil {
+			return nil
+		}
+		content_type := ndjsonContentType
+		if format == exportZIP {
+			content_type = zipContentType
+		}
+		c.Header("Content-Type", content_type)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": name + "." + format,
+		}))
+		c.Status(200)
+
+		var out io.Writer = c.Writer
+		if format == exportZIP {
+			archive = zip.NewWriter(c.Writer)
+			entry, err := archive.Create("posts.jsonl")
+			if err != nil {
+				return err
+			}
+			out = entry
+		}
+		encoder = json.NewEncoder(out)
+		return nil
+	}
+
+	err := postdb.Stream(ctx, filter, func(post models.Post) error {
+		if err := begin(); err != nil {
+			return err
+		}
+		return encoder.Encode(post)
+	})
+	if err != nil {
+		return err
+	}
+	// no matching posts gives an empty file
+	if err := begin(); err != nil {
+		return err
+	}
+	if archive != nil {
+		return archive.Close()
+	}
+	return nil
+}
+
+// serveExport answers with exportPosts. Past the first post an error can
+// only cut the download short.
+func serveExport(ctx context.Context, c *gin.Context, postdb models.PostDatabase, filter models.PostFilter, name string) {
+
+	format := c.DefaultQuery("format", exportJSONL)
+	if format != exportJSONL && format != exportZIP {
+		abortWithError(c, fmt.Errorf("%w: format must be %s or %s", errBadRequest, exportJSONL, exportZIP))
+		return
+	}
+	if export_err := exportPosts(ctx, c, postdb, filter, name, format); export_err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			abortWithError(c, export_err)
+			return
+		}
+		logging.FromContext(ctx).Error("post export failed", zap.String("file", name), zap.Error(export_err))
+		c.Abort()
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/vinhut/posted/logging"
+	"github.com/vinhut/posted/models"
+	"github.com/vinhut/posted/postpb"
+	"github.com/vinhut/posted/propagation"
+	"github.com/vinhut/posted/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcAuthRequired lists the methods that refuse calls without a token.
+var grpcAuthRequired = map[string]bool{
+	"/post.v1.PostService/GetPost":       true,
+	"/post.v1.PostService/DeletePost":    true,
+	"/post.v1.PostService/ListUserPosts": true,
+}
+
+type grpcUserKey struct{}
+
+// newGRPCServer serves postpb.PostService from the same dependencies as
+// setupRouter. Callers that name the author of a post must send
+// service_token.
+func newGRPCServer(postdb models.PostDatabase, authservice services.AuthService, posts *postCache, service_token string) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		grpcTracingInterceptor(opentracing.GlobalTracer()),
+		grpcAccessLogInterceptor(),
+		grpcRecoveryInterceptor(),
+		grpcAuthInterceptor(authservice),
+	))
+	postpb.RegisterPostServiceServer(server, &grpcPostServer{
+		postdb:       postdb,
+		posts:        posts,
+		serviceToken: service_token,
+	})
+	return server
+}
+
+// metadataCarrier lets the tracer read and write span contexts in gRPC
+// metadata. Keys are lower case there, which the B3 propagator accepts.
+type metadataCarrier metadata.MD
+
+func (carrier metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for key, values := range carrier {
+		for _, value := range values {
+			if err := handler(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (carrier metadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	carrier[key] = append(carrier[key], val)
+}
+
+// grpcTracingInterceptor is the gRPC counterpart of requestIDMiddleware and
+// tracingMiddleware: it assigns the request id and starts a server span
+// joined to the caller's trace.
+func grpcTracingInterceptor(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		request_id := ""
+		if values := md.Get(propagation.RequestIDHeader); len(values) > 0 {
+			request_id = values[0]
+		}
+		if !validRequestID(request_id) {
+			request_id = newRequestID()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(propagation.RequestIDHeader, request_id))
+		ctx = propagation.WithRequestID(ctx, request_id)
+
+		parent, _ := tracer.Extract(opentracing.HTTPHeaders, metadataCarrier(md))
+		span := tracer.StartSpan("grpc "+info.FullMethod, ext.RPCServerOption(parent))
+		defer span.Finish()
+		ext.Component.Set(span, "grpc")
+
+		resp, err := handler(opentracing.ContextWithSpan(ctx, span), req)
+
+		code := status.Code(err)
+		span.SetTag("grpc.code", code.String())
+		if err != nil {
+			span.LogFields(otlog.String("event", "error"), otlog.String("message", err.Error()))
+			if grpcServerFault(code) {
+				ext.Error.Set(span, true)
+			}
+		}
+		return resp, err
+	}
+}
+
+func grpcServerFault(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// grpcAccessLogInterceptor writes one JSON line per call, like
+// accessLogMiddleware.
+func grpcAccessLogInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		fields := []zap.Field{
+			zap.String("method", info.FullMethod),
+			zap.String("code", code.String()),
+			zap.Duration("latency", time.Since(start)),
+		}
+		if err != nil {
+			fields = append(fields, zap.String("errors", err.Error()))
+		}
+
+		logger := logging.FromContext(ctx)
+		if grpcServerFault(code) {
+			logger.Error("grpc request", fields...)
+		} else {
+			logger.Info("grpc request", fields...)
+		}
+		return resp, err
+	}
+}
+
+// grpcRecoveryInterceptor turns a panicking handler into an Internal error
+// instead of taking the process down.
+func grpcRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logging.FromContext(ctx).Error("panic",
+					zap.String("method", info.FullMethod),
+					zap.Any("panic", r),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				err = grpcError(fmt.Errorf("panic: %v", r))
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// grpcAuthInterceptor checks the token sent as "authorization: Bearer
+// <token>" and stores the caller's user data in the context. Calls without
+// a token only reach the methods not in grpcAuthRequired.
+func grpcAuthInterceptor(authservice services.AuthService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+		token := grpcToken(ctx)
+		if token == "" {
+			if grpcAuthRequired[info.FullMethod] {
+				return nil, grpcError(services.ErrUnauthorized)
+			}
+			return handler(ctx, req)
+		}
+
+		user_data, check_err := checkUser(ctx, authservice, token)
+		if check_err != nil {
+			return nil, grpcError(check_err)
+		}
+		return handler(context.WithValue(ctx, grpcUserKey{}, user_data), req)
+	}
+}
+
+func grpcToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	parts := strings.SplitN(values[0], " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// grpcServiceToken reads the token other services send in the
+// serviceTokenHeader metadata.
+func grpcServiceToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(serviceTokenHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func grpcUser(ctx context.Context) (map[string]interface{}, bool) {
+	user_data, ok := ctx.Value(grpcUserKey{}).(map[string]interface{})
+	return user_data, ok
+}
+
+// grpcError converts a domain error into a gRPC status through the same
+// table errorStatus uses for HTTP, so both APIs agree on what went wrong.
+// Validation failures carry their fields as BadRequest details.
+func grpcError(err error) error {
+	http_status, _, message := errorStatus(err)
+
+	code := codes.Internal
+	switch http_status {
+	case 400, 422:
+		code = codes.InvalidArgument
+	case 401:
+		code = codes.Unauthenticated
+	case 403:
+		code = codes.PermissionDenied
+	case 404:
+		code = codes.NotFound
+	case 409:
+		code = codes.AlreadyExists
+	case 501:
+		code = codes.Unimplemented
+	case 503:
+		code = codes.Unavailable
+	}
+	st := status.New(code, message)
+
+	var validation_err *validationError
+	if errors.As(err, &validation_err) {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(validation_err.Fields))
+		for i, field := range validation_err.Fields {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+		}
+		if detailed, detail_err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); detail_err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+type grpcPostServer struct {
+	postpb.UnimplementedPostServiceServer
+	postdb       models.PostDatabase
+	posts        *postCache
+	serviceToken string
+}
+
+func (server *grpcPostServer) GetPost(ctx context.Context, req *postpb.GetPostRequest) (*postpb.Post, error) {
+
+	// The cache holds the same JSON the HTTP API serves.
+	post_json, get_err := server.posts.Get(ctx, req.Id)
+	if get_err != nil {
+		return nil, grpcError(fmt.Errorf("post %s: %w", req.Id, get_err))
+	}
+	post := &models.Post{}
+	if err := json.Unmarshal(post_json, post); err != nil {
+		return nil, grpcError(err)
+	}
+	return postToProto(post), nil
+}
+
+func (server *grpcPostServer) CreatePost(ctx context.Context, req *postpb.CreatePostRequest) (*postpb.CreatePostResponse, error) {
+
+	post_req := createPostRequest{
+		ImgURL:  req.ImageUrl,
+		Caption: req.Caption,
+		Tags:    req.Tags,
+	}
+	if post_req.Tags == nil {
+		post_req.Tags = make([]string, 0)
+	}
+
+	new_post := &models.Post{
+		Postid:  primitive.NewObjectIDFromTimestamp(time.Now()),
+		Created: time.Now(),
+	}
+
+	if user_data, authenticated := grpcUser(ctx); authenticated {
+		if validate_err := post_req.validate(); validate_err != nil {
+			return nil, grpcError(validate_err)
+		}
+		new_post.Uid = userField(user_data, "uid")
+		new_post.Username = userField(user_data, "username")
+		new_post.Screenname = userField(user_data, "screenname")
+		new_post.Avatarurl = userField(user_data, "avatarurl")
+		new_post.Verified, _ = strconv.ParseBool(userField(user_data, "verified"))
+	} else {
+		// only other services may write posts as whoever they name
+		if service_err := checkServiceToken(server.serviceToken, grpcServiceToken(ctx)); service_err != nil {
+			return nil, grpcError(service_err)
+		}
+		author := req.Author
+		if author == nil {
+			author = &postpb.Author{}
+		}
+		internal_req := internalCreatePostRequest{
+			createPostRequest: post_req,
+			Uid:               author.Uid,
+			Username:          author.Username,
+			Screenname:        author.Screenname,
+			Avatarurl:         author.AvatarUrl,
+		}
+		if validate_err := internal_req.validate(); validate_err != nil {
+			return nil, grpcError(validate_err)
+		}
+		new_post.Uid = internal_req.Uid
+		new_post.Username = internal_req.Username
+		new_post.Screenname = internal_req.Screenname
+		new_post.Avatarurl = internal_req.Avatarurl
+	}
+	new_post.Imageurl = post_req.ImgURL
+	new_post.Caption = post_req.Caption
+	new_post.Tag = post_req.Tags
+
+	if _, create_err := server.postdb.Create(ctx, new_post); create_err != nil {
+		return nil, grpcError(create_err)
+	}
+	return &postpb.CreatePostResponse{Id: new_post.Postid.Hex()}, nil
+}
+
+func (server *grpcPostServer) DeletePost(ctx context.Context, req *postpb.DeletePostRequest) (*postpb.DeletePostResponse, error) {
+
+	user_data, _ := grpcUser(ctx)
+	post := &models.Post{}
+	if find_err := server.postdb.Find(ctx, "_id", req.Id, post); find_err != nil {
+		return nil, grpcError(fmt.Errorf("post %s: %w", req.Id, find_err))
+	}
+	if owner_err := post.CheckOwner(userField(user_data, "uid")); owner_err != nil {
+		return nil, grpcError(fmt.Errorf("post %s: %w", req.Id, owner_err))
+	}
+
+	if _, delete_err := server.postdb.Delete(ctx, req.Id); delete_err != nil {
+		return nil, grpcError(fmt.Errorf("post %s: %w", req.Id, delete_err))
+	}
+	server.posts.Invalidate(ctx, req.Id)
+	return &postpb.DeletePostResponse{}, nil
+}
+
+func (server *grpcPostServer) ListPosts(ctx context.Context, req *postpb.ListPostsRequest) (*postpb.ListPostsResponse, error) {
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 8
+	}
+	ids, findall_err := server.postdb.FindAll(ctx, strconv.Itoa(int(limit)))
+	if findall_err != nil {
+		return nil, grpcError(findall_err)
+	}
+	return &postpb.ListPostsResponse{Ids: ids}, nil
+}
+
+func (server *grpcPostServer) ListUserPosts(ctx context.Context, req *postpb.ListUserPostsRequest) (*postpb.ListPostsResponse, error) {
+
+	ids, findmulti_err := server.postdb.FindMulti(ctx, "username", req.Username)
+	if findmulti_err != nil {
+		return nil, grpcError(findmulti_err)
+	}
+	return &postpb.ListPostsResponse{Ids: ids}, nil
+}
+
+func postToProto(post *models.Post) *postpb.Post {
+	return &postpb.Post{
+		Id:           post.Postid.Hex(),
+		Uid:          post.Uid,
+		Username:     post.Username,
+		Screenname:   post.Screenname,
+		AvatarUrl:    post.Avatarurl,
+		Verified:     post.Verified,
+		ImageUrl:     post.Imageurl,
+		Caption:      post.Caption,
+		LikeCount:    int64(post.Likecount),
+		Private:      post.Private,
+		CommentCount: int64(post.Commentcount),
+		ViewCount:    int64(post.Viewcount),
+		Created:      timestamppb.New(post.Created),
+		Tags:         post.Tag,
+	}
+}
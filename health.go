@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"context"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds each dependency check so one hanging dependency
+// can't hold the probe past the kubelet's own timeout.
+const readinessTimeout = 2 * time.Second
+
+type dependencyCheck func(context.Context) error
+
+// readinessHandler pings every dependency concurrently and answers 503
+// unless all of them are reachable, with the status of each in the body.
+func readinessHandler(checks map[string]dependencyCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		results := make(map[string]string, len(checks))
+		ready := true
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check dependencyCheck) {
+				defer wg.Done()
+				err := check(ctx)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					results[name] = err.Error()
+					ready = false
+				} else {
+					results[name] = "ok"
+				}
+			}(name, check)
+		}
+		wg.Wait()
+
+		if !ready {
+			c.JSON(503, gin.H{"status": "unavailable", "checks": results})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "checks": results})
+	}
+}
@@ -0,0 +1,11 @@
+package helpers
+
+import "errors"
+
+// Errors returned by DatabaseHelper implementations in place of driver
+// errors, so callers don't have to know which database sits underneath.
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrInvalidID = errors.New("invalid id")
+	ErrConflict  = errors.New("already exists")
+)
@@ -0,0 +1,516 @@
+package helpers
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryHelper is a DatabaseHelper that keeps every collection in memory.
+// Documents go through the same BSON encoding as with Mongo, so struct
+// tags, lookups and errors behave the same. Queries are evaluated like
+// Mongo does, unsorted results come in insertion order.
+type MemoryHelper struct {
+	mu          sync.RWMutex
+	collections map[string][]bson.Raw
+
+	// transactions run one at a time
+	tx_mu sync.Mutex
+}
+
+func NewMemoryDatabase() DatabaseHelper {
+	return &MemoryHelper{
+		collections: make(map[string][]bson.Raw),
+	}
+}
+
+func (mdb *MemoryHelper) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (mdb *MemoryHelper) Close(ctx context.Context) error {
+	return nil
+}
+
+// Migrate has nothing to do: there are no indexes and no old data.
+func (mdb *MemoryHelper) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// Reindex has nothing to do, there are no indexes.
+func (mdb *MemoryHelper) Reindex(ctx context.Context) error {
+	return nil
+}
+
+// indexOf returns the position of the document with the given _id.
+func (mdb *MemoryHelper) indexOf(collectionName string, id primitive.ObjectID) int {
+	for i, doc := range mdb.collections[collectionName] {
+		if doc_id, ok := doc.Lookup("_id").ObjectIDOK(); ok && doc_id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (mdb *MemoryHelper) Query(ctx context.Context, collectionName, key, value string, data interface{}) error {
+
+	value_hex, value_err := primitive.ObjectIDFromHex(value)
+	if value_err != nil {
+		return ErrInvalidID
+	}
+
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	for _, doc := range mdb.collections[collectionName] {
+		if id, ok := doc.Lookup(key).ObjectIDOK(); ok && id == value_hex {
+			return bson.Unmarshal(doc, data)
+		}
+	}
+	return ErrNotFound
+}
+
+func (mdb *MemoryHelper) Find(ctx context.Context, collectionName string, query Query, results interface{}) error {
+
+	slice := reflect.ValueOf(results)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("results must be a pointer to a slice")
+	}
+	slice = slice.Elem()
+
+	docs, err := mdb.selectDocs(collectionName, query)
+	if err != nil {
+		return err
+	}
+	slice.Set(slice.Slice(0, 0))
+	for _, doc := range docs {
+		elem := reflect.New(slice.Type().Elem())
+		if err := bson.Unmarshal(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+	return nil
+}
+
+// Stream works on a snapshot of the collection, fn may write to it.
+func (mdb *MemoryHelper) Stream(ctx context.Context, collectionName string, query Query, fn func(decode func(interface{}) error) error) error {
+
+	docs, err := mdb.selectDocs(collectionName, query)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		doc := doc
+		if err := fn(func(data interface{}) error { return bson.Unmarshal(doc, data) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectDocs runs query over a collection the way Mongo would.
+func (mdb *MemoryHelper) selectDocs(collectionName string, query Query) ([]bson.Raw, error) {
+
+	values, err := conditionValues(query)
+	if err != nil {
+		return nil, err
+	}
+
+	mdb.mu.RLock()
+	var docs []bson.Raw
+	for _, doc := range mdb.collections[collectionName] {
+		if matchAll(doc, query, values) {
+			docs = append(docs, doc)
+		}
+	}
+	mdb.mu.RUnlock()
+
+	if len(query.sort) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			for _, key := range query.sort {
+				order := compareValues(docs[i].Lookup(key.Key), docs[j].Lookup(key.Key))
+				if order != 0 {
+					return order*key.Value.(int) < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if query.skip > 0 {
+		if query.skip >= int64(len(docs)) {
+			return nil, nil
+		}
+		docs = docs[query.skip:]
+	}
+	limit := query.limit
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+
+	if len(query.fields) > 0 {
+		for i, doc := range docs {
+			projected, err := project(doc, query.fields)
+			if err != nil {
+				return nil, err
+			}
+			docs[i] = projected
+		}
+	}
+	return docs, nil
+}
+
+// conditionValues encodes the values of the conditions of query once, for
+// matchAll.
+func conditionValues(query Query) ([]bson.RawValue, error) {
+	values := make([]bson.RawValue, len(query.conditions))
+	for i, cond := range query.conditions {
+		value_type, data, err := bson.MarshalValue(cond.value)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = bson.RawValue{Type: value_type, Value: data}
+	}
+	return values, nil
+}
+
+func matchAll(doc bson.Raw, query Query, values []bson.RawValue) bool {
+	for i, cond := range query.conditions {
+		if !matchCondition(doc.Lookup(cond.field), cond.op, values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCondition applies op to field. Like a Mongo query, a condition on
+// an array holds when it holds for one of the elements, except Ne which
+// must hold for all of them, and Eq null matches a missing field.
+func matchCondition(field bson.RawValue, op Op, value bson.RawValue) bool {
+
+	if op == In {
+		elements, err := value.Array().Values()
+		if err != nil {
+			return false
+		}
+		for _, element := range elements {
+			if matchCondition(field, Eq, element) {
+				return true
+			}
+		}
+		return false
+	}
+	if op == Ne {
+		return !matchCondition(field, Eq, value)
+	}
+
+	if field.Type == 0 {
+		return op == Eq && value.Type == bsontype.Null
+	}
+	if field.Type == bsontype.Array && value.Type != bsontype.Array {
+		elements, err := field.Array().Values()
+		if err != nil {
+			return false
+		}
+		for _, element := range elements {
+			if matchCondition(element, op, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	order, ok := compareSameKind(field, value)
+	if !ok {
+		return false
+	}
+	switch op {
+	case Eq:
+		return order == 0
+	case Gt:
+		return order > 0
+	case Gte:
+		return order >= 0
+	case Lt:
+		return order < 0
+	case Lte:
+		return order <= 0
+	}
+	return false
+}
+
+// compareSameKind orders two values of comparable types: numbers, strings,
+// object ids, dates, booleans and nulls.
+func compareSameKind(a, b bson.RawValue) (int, bool) {
+	if a_num, ok := numberOf(a); ok {
+		b_num, ok := numberOf(b)
+		if !ok {
+			return 0, false
+		}
+		return compareFloats(a_num, b_num), true
+	}
+	if a.Type != b.Type {
+		return 0, false
+	}
+	switch a.Type {
+	case bsontype.String:
+		return strings.Compare(a.StringValue(), b.StringValue()), true
+	case bsontype.ObjectID:
+		a_id, b_id := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(a_id[:], b_id[:]), true
+	case bsontype.DateTime:
+		return compareFloats(float64(a.DateTime()), float64(b.DateTime())), true
+	case bsontype.Boolean:
+		return compareFloats(boolToFloat(a.Boolean()), boolToFloat(b.Boolean())), true
+	case bsontype.Null:
+		return 0, true
+	}
+	// other types only tell equal from not equal
+	return 0, bytes.Equal(a.Value, b.Value)
+}
+
+// compareValues is the sort order. Values that don't compare, a missing
+// field first, are ordered by type.
+func compareValues(a, b bson.RawValue) int {
+	if order, ok := compareSameKind(a, b); ok {
+		return order
+	}
+	return compareFloats(float64(a.Type), float64(b.Type))
+}
+
+func numberOf(value bson.RawValue) (float64, bool) {
+	switch value.Type {
+	case bsontype.Int32:
+		return float64(value.Int32()), true
+	case bsontype.Int64:
+		return float64(value.Int64()), true
+	case bsontype.Double:
+		return value.Double(), true
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// project keeps _id and fields of doc.
+func project(doc bson.Raw, fields []string) (bson.Raw, error) {
+	var full bson.D
+	if err := bson.Unmarshal(doc, &full); err != nil {
+		return nil, err
+	}
+	keep := map[string]bool{"_id": true}
+	for _, field := range fields {
+		keep[field] = true
+	}
+	projected := bson.D{}
+	for _, element := range full {
+		if keep[element.Key] {
+			projected = append(projected, element)
+		}
+	}
+	return bson.Marshal(projected)
+}
+
+func (mdb *MemoryHelper) Insert(ctx context.Context, collectionName string, data interface{}) error {
+
+	doc, err := bson.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	id, has_id := bson.Raw(doc).Lookup("_id").ObjectIDOK()
+	if !has_id {
+		// Mongo adds an _id to documents that come without one.
+		var fields bson.D
+		if err := bson.Unmarshal(doc, &fields); err != nil {
+			return err
+		}
+		id = primitive.NewObjectID()
+		doc, err = bson.Marshal(append(bson.D{{Key: "_id", Value: id}}, fields...))
+		if err != nil {
+			return err
+		}
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	if mdb.indexOf(collectionName, id) >= 0 {
+		return ErrConflict
+	}
+	mdb.collections[collectionName] = append(mdb.collections[collectionName], doc)
+	return nil
+}
+
+func (mdb *MemoryHelper) InsertMany(ctx context.Context, collectionName string, docs []interface{}) (int64, error) {
+	var inserted int64
+	for _, doc := range docs {
+		err := mdb.Insert(ctx, collectionName, doc)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return inserted, err
+		}
+		inserted++
+	}
+	return inserted, nil
+}
+
+func (mdb *MemoryHelper) Update(ctx context.Context, collectionName, id string, fields map[string]interface{}) error {
+
+	id_hex, objid_err := primitive.ObjectIDFromHex(id)
+	if objid_err != nil {
+		return ErrInvalidID
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	i := mdb.indexOf(collectionName, id_hex)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	updated, err := setFields(mdb.collections[collectionName][i], fields)
+	if err != nil {
+		return err
+	}
+	mdb.collections[collectionName][i] = updated
+	return nil
+}
+
+// UpdateMany ignores the sort, projection and limits of query, like Mongo.
+func (mdb *MemoryHelper) UpdateMany(ctx context.Context, collectionName string, query Query, fields map[string]interface{}) (int64, error) {
+
+	values, err := conditionValues(query)
+	if err != nil {
+		return 0, err
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	var matched int64
+	for i, doc := range mdb.collections[collectionName] {
+		if !matchAll(doc, query, values) {
+			continue
+		}
+		updated, err := setFields(doc, fields)
+		if err != nil {
+			return matched, err
+		}
+		mdb.collections[collectionName][i] = updated
+		matched++
+	}
+	return matched, nil
+}
+
+// setFields is $set: it replaces the given fields of doc or appends them.
+func setFields(raw bson.Raw, fields map[string]interface{}) (bson.Raw, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for key, value := range fields {
+		set := false
+		for j := range doc {
+			if doc[j].Key == key {
+				doc[j].Value = value
+				set = true
+			}
+		}
+		if !set {
+			doc = append(doc, bson.E{Key: key, Value: value})
+		}
+	}
+	return bson.Marshal(doc)
+}
+
+func (mdb *MemoryHelper) Delete(ctx context.Context, collectionName, postid string) error {
+
+	postid_hex, objid_err := primitive.ObjectIDFromHex(postid)
+	if objid_err != nil {
+		return ErrInvalidID
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	i := mdb.indexOf(collectionName, postid_hex)
+	if i < 0 {
+		return ErrNotFound
+	}
+	docs := mdb.collections[collectionName]
+	mdb.collections[collectionName] = append(docs[:i:i], docs[i+1:]...)
+	return nil
+}
+
+func (mdb *MemoryHelper) DeleteMany(ctx context.Context, collectionName string, query Query) (int64, error) {
+
+	values, err := conditionValues(query)
+	if err != nil {
+		return 0, err
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	var kept []bson.Raw
+	for _, doc := range mdb.collections[collectionName] {
+		if !matchAll(doc, query, values) {
+			kept = append(kept, doc)
+		}
+	}
+	deleted := int64(len(mdb.collections[collectionName]) - len(kept))
+	mdb.collections[collectionName] = kept
+	return deleted, nil
+}
+
+// WithTransaction undoes every write fn made when it returns an error.
+// Writes made outside the transaction meanwhile are undone as well, which
+// is fine for tests and local runs.
+func (mdb *MemoryHelper) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
+
+	mdb.tx_mu.Lock()
+	defer mdb.tx_mu.Unlock()
+
+	mdb.mu.RLock()
+	snapshot := make(map[string][]bson.Raw, len(mdb.collections))
+	for name, docs := range mdb.collections {
+		snapshot[name] = append([]bson.Raw(nil), docs...)
+	}
+	mdb.mu.RUnlock()
+
+	if err := fn(ctx); err != nil {
+		mdb.mu.Lock()
+		mdb.collections = snapshot
+		mdb.mu.Unlock()
+		return err
+	}
+	return nil
+}
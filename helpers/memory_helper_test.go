@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"context"
+	"errors"
+	"testing"
+)
+
+// testPost is stored like the posts collection documents.
+type testPost struct {
+	Postid   primitive.ObjectID `bson:"_id,omitempty"`
+	Username string
+	Caption  string
+	Tag      []string
+}
+
+func TestMemoryDatabase(t *testing.T) {
+
+	ctx := context.Background()
+	db := NewMemoryDatabase()
+
+	first := testPost{Postid: primitive.NewObjectID(), Username: "alice", Tag: []string{"go", "gin"}}
+	second := testPost{Postid: primitive.NewObjectID(), Username: "bob", Tag: []string{"go"}}
+	assert.NoError(t, db.Insert(ctx, "posts", first))
+	assert.NoError(t, db.Insert(ctx, "posts", second))
+	assert.Equal(t, ErrConflict, db.Insert(ctx, "posts", first))
+
+	found := testPost{}
+	assert.NoError(t, db.Query(ctx, "posts", "_id", second.Postid.Hex(), &found))
+	assert.Equal(t, "bob", found.Username)
+	assert.Equal(t, ErrInvalidID, db.Query(ctx, "posts", "_id", "bad", &found))
+	assert.Equal(t, ErrNotFound, db.Query(ctx, "posts", "_id", primitive.NewObjectID().Hex(), &found))
+
+	posts := NewRepository[testPost](db, "posts")
+	by_user, err := posts.Find(ctx, NewQuery().Where("username", Eq, "alice"))
+	assert.NoError(t, err)
+	if assert.Len(t, by_user, 1) {
+		assert.Equal(t, first, by_user[0])
+	}
+	by_tag, err := posts.Find(ctx, NewQuery().Where("tag", Eq, "go"))
+	assert.NoError(t, err)
+	assert.Len(t, by_tag, 2)
+	not_gin, _ := posts.Find(ctx, NewQuery().Where("tag", Ne, "gin"))
+	assert.Len(t, not_gin, 1)
+	by_name, _ := posts.Find(ctx, NewQuery().Where("username", In, []string{"bob", "carol"}))
+	assert.Len(t, by_name, 1)
+	after_first, _ := posts.Find(ctx, NewQuery().Where("_id", Gt, first.Postid))
+	assert.Len(t, after_first, 1)
+
+	newest, err := posts.Find(ctx, NewQuery().Sort("username", Descending).Limit(1).Select("_id"))
+	assert.NoError(t, err)
+	if assert.Len(t, newest, 1) {
+		assert.Equal(t, testPost{Postid: second.Postid}, newest[0])
+	}
+	all, _ := posts.Find(ctx, NewQuery())
+	assert.Len(t, all, 2)
+	skipped, _ := posts.Find(ctx, NewQuery().Skip(1))
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, second.Postid, skipped[0].Postid)
+	}
+
+	var streamed []string
+	stream_err := posts.Stream(ctx, NewQuery().Sort("username", Ascending), func(post testPost) error {
+		streamed = append(streamed, post.Username)
+		return nil
+	})
+	assert.NoError(t, stream_err)
+	assert.Equal(t, []string{"alice", "bob"}, streamed)
+	stop := errors.New("stop")
+	assert.Equal(t, stop, posts.Stream(ctx, NewQuery(), func(testPost) error { return stop }))
+
+	assert.NoError(t, db.Update(ctx, "posts", first.Postid.Hex(), map[string]interface{}{"caption": "edited"}))
+	assert.NoError(t, db.Query(ctx, "posts", "_id", first.Postid.Hex(), &found))
+	assert.Equal(t, "edited", found.Caption)
+	assert.Equal(t, "alice", found.Username)
+
+	// a failed transaction leaves nothing behind
+	tx_err := db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+		assert.NoError(t, db.Delete(tx_ctx, "posts", first.Postid.Hex()))
+		return db.Delete(tx_ctx, "posts", first.Postid.Hex())
+	})
+	assert.Equal(t, ErrNotFound, tx_err)
+	assert.NoError(t, db.Query(ctx, "posts", "_id", first.Postid.Hex(), &found))
+
+	assert.NoError(t, db.Delete(ctx, "posts", first.Postid.Hex()))
+	assert.Equal(t, ErrNotFound, db.Delete(ctx, "posts", first.Postid.Hex()))
+	assert.Equal(t, ErrInvalidID, db.Delete(ctx, "posts", "bad"))
+
+}
@@ -0,0 +1,32 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "post_service",
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Latency of MongoDBHelper operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"collection", "operation"})
+
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "mongo_operation_errors_total",
+		Help:      "MongoDBHelper operations that returned an error.",
+	}, []string{"collection", "operation"})
+)
+
+// observeMongo is deferred at the top of every MongoDBHelper operation with
+// a pointer to its named error result.
+func observeMongo(collection, operation string, start time.Time, err *error) {
+	mongoDuration.WithLabelValues(collection, operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		mongoErrors.WithLabelValues(collection, operation).Inc()
+	}
+}
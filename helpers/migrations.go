@@ -0,0 +1,278 @@
+package helpers
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const migrationsCollection = "schema_migrations"
+
+// namespaceNotFoundCode is what listing the indexes of a collection that
+// doesn't exist yet fails with.
+const namespaceNotFoundCode = 26
+
+// Index is an index Migrate keeps in place. Migrate can't change the keys
+// of an index that exists, Reindex replaces it.
+type Index struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// Partial limits the index to the documents it matches.
+	Partial bson.D
+}
+
+// Migration is a one-off change to stored data. Migrate runs every
+// migration once, in Version order, and records it in schema_migrations.
+// Up should be safe to run again: a migration that fails midway, or runs
+// on two instances at once, is repeated.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(context.Context, *mongo.Database) error
+}
+
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	Applied     time.Time `bson:"applied"`
+}
+
+var Indexes = []Index{
+	{Collection: "posts", Name: "username_1", Keys: bson.D{{Key: "username", Value: 1}}},
+	{Collection: "posts", Name: "uid_1", Keys: bson.D{{Key: "uid", Value: 1}}},
+	// newest first, for feeds
+	{Collection: "posts", Name: "created_-1", Keys: bson.D{{Key: "created", Value: -1}}},
+	{Collection: "posts", Name: "tag_1", Keys: bson.D{{Key: "tag", Value: 1}}},
+	{Collection: "posts", Name: "caption_text_tag_text", Keys: bson.D{{Key: "caption", Value: "text"}, {Key: "tag", Value: "text"}}},
+	{Collection: "user_deletions", Name: "uid_1__id_-1", Keys: bson.D{{Key: "uid", Value: 1}, {Key: "_id", Value: -1}}},
+	{Collection: "user_deletions", Name: "status_1", Keys: bson.D{{Key: "status", Value: 1}}},
+	// one unfinished deletion per user
+	{Collection: "user_deletions", Name: "uid_1_active", Keys: bson.D{{Key: "uid", Value: 1}},
+		Unique: true, Partial: bson.D{{Key: "active", Value: true}}},
+}
+
+var Migrations = []Migration{
+	{
+		// nil slices used to be stored as null, which reads back as null
+		// in the API instead of an empty list
+		Version:     1,
+		Description: "store missing post tags as empty arrays",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("posts").UpdateMany(ctx,
+				bson.M{"tag": nil},
+				bson.M{"$set": bson.M{"tag": bson.A{}}},
+			)
+			return err
+		},
+	},
+	{
+		// user deletions are claimed by the instance that runs them, the
+		// unfinished ones from before are up for grabs. Only the newest
+		// job of a user stays, the older ones are superseded.
+		Version:     2,
+		Description: "make unfinished user deletions claimable",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			jobs := db.Collection("user_deletions")
+			cur, err := jobs.Find(ctx,
+				bson.M{"status": bson.M{"$in": bson.A{"pending", "running"}}},
+				options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+			if err != nil {
+				return err
+			}
+			var unfinished []struct {
+				ID  interface{} `bson:"_id"`
+				Uid string      `bson:"uid"`
+			}
+			if err := cur.All(ctx, &unfinished); err != nil {
+				return err
+			}
+			seen := make(map[string]bool)
+			for _, job := range unfinished {
+				change := bson.M{"active": true, "owner": "", "lease_until": time.Time{}}
+				if seen[job.Uid] {
+					change = bson.M{"active": false, "status": "failed", "error": "superseded by a newer job"}
+				}
+				seen[job.Uid] = true
+				if _, err := jobs.UpdateByID(ctx, job.ID, bson.M{"$set": change}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// Migrate applies the pending Migrations, then creates the Indexes that
+// are missing. Running it again is a no-op.
+func (mdb *MongoDBHelper) Migrate(ctx context.Context) error {
+	return migrate(ctx, mdb.db, Migrations, Indexes)
+}
+
+func migrate(ctx context.Context, db *mongo.Database, migrations []Migration, indexes []Index) error {
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	last := 0
+	for _, migration := range migrations {
+		if migration.Version <= last {
+			return fmt.Errorf("migration %d is out of order", migration.Version)
+		}
+		last = migration.Version
+		if applied[migration.Version] {
+			continue
+		}
+
+		zap.L().Info("applying migration",
+			zap.Int("version", migration.Version),
+			zap.String("description", migration.Description),
+		)
+		if err := migration.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+		_, err := db.Collection(migrationsCollection).InsertOne(ctx, migrationRecord{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     time.Now(),
+		})
+		// another instance recorded it first
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("recording migration %d: %w", migration.Version, err)
+		}
+	}
+
+	return ensureIndexes(ctx, db, indexes)
+}
+
+// Reindex makes the indexes of the collections in Indexes match it: it
+// drops the indexes that aren't listed or whose keys changed, then creates
+// the missing ones. Queries that used a dropped index are slow until it is
+// built again.
+func (mdb *MongoDBHelper) Reindex(ctx context.Context) error {
+	return reindex(ctx, mdb.db, Indexes)
+}
+
+func reindex(ctx context.Context, db *mongo.Database, indexes []Index) error {
+
+	var collections []string
+	wanted := make(map[string]map[string]Index)
+	for _, index := range indexes {
+		if _, seen := wanted[index.Collection]; !seen {
+			collections = append(collections, index.Collection)
+			wanted[index.Collection] = make(map[string]Index)
+		}
+		wanted[index.Collection][index.Name] = index
+	}
+
+	for _, collection := range collections {
+		cur, err := db.Collection(collection).Indexes().List(ctx)
+		var command_err mongo.CommandError
+		if errors.As(err, &command_err) && command_err.Code == namespaceNotFoundCode {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("indexes on %s: %w", collection, err)
+		}
+		var existing []struct {
+			Name string `bson:"name"`
+			Keys bson.D `bson:"key"`
+		}
+		if err := cur.All(ctx, &existing); err != nil {
+			return fmt.Errorf("indexes on %s: %w", collection, err)
+		}
+
+		for _, index := range existing {
+			if index.Name == "_id_" {
+				continue
+			}
+			if want, listed := wanted[collection][index.Name]; listed && sameKeys(want.Keys, index.Keys) {
+				continue
+			}
+			zap.L().Info("dropping index", zap.String("collection", collection), zap.String("index", index.Name))
+			if _, err := db.Collection(collection).Indexes().DropOne(ctx, index.Name); err != nil {
+				return fmt.Errorf("dropping %s on %s: %w", index.Name, collection, err)
+			}
+		}
+	}
+	return ensureIndexes(ctx, db, indexes)
+}
+
+// sameKeys compares the keys of a declared index with the ones the server
+// lists. The server stores text indexes under other keys, those only
+// compare by name.
+func sameKeys(declared, listed bson.D) bool {
+	for _, key := range declared {
+		if key.Value == "text" {
+			return true
+		}
+	}
+	if len(declared) != len(listed) {
+		return false
+	}
+	for i := range declared {
+		// numbers come back as int32 or double
+		if declared[i].Key != listed[i].Key || fmt.Sprint(declared[i].Value) != fmt.Sprint(listed[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[int]bool, error) {
+
+	cur, err := db.Collection(migrationsCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var records []migrationRecord
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// ensureIndexes creates indexes in one call per collection. The server
+// skips the ones that already exist with the same keys.
+func ensureIndexes(ctx context.Context, db *mongo.Database, indexes []Index) error {
+
+	var collections []string
+	models := make(map[string][]mongo.IndexModel)
+	for _, index := range indexes {
+		if _, seen := models[index.Collection]; !seen {
+			collections = append(collections, index.Collection)
+		}
+		opts := options.Index().SetName(index.Name)
+		if index.Unique {
+			opts.SetUnique(true)
+		}
+		if index.Partial != nil {
+			opts.SetPartialFilterExpression(index.Partial)
+		}
+		models[index.Collection] = append(models[index.Collection], mongo.IndexModel{
+			Keys:    index.Keys,
+			Options: opts,
+		})
+	}
+
+	for _, collection := range collections {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models[collection]); err != nil {
+			return fmt.Errorf("indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
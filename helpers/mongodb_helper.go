@@ -1,161 +1,313 @@
 package helpers
 
 import (
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.uber.org/zap"
 
 	//"net/http"
 	"context"
-	"fmt"
-	"log"
-	"os"
-	"reflect"
-	"strconv"
+	"errors"
+	"sync"
 	"time"
 )
 
+// queryTimeout caps every database call. A tighter deadline on the caller's
+// context wins.
+const queryTimeout = 30 * time.Second
+
+const duplicateKeyCode = 11000
+
 type DatabaseHelper interface {
-	Query(string, string, string, interface{}) error
-	FindMulti(string, string, string, interface{}) ([]interface{}, error)
-	FindAll(string, string, interface{}) ([]interface{}, error)
-	Insert(string, interface{}) error
-	Delete(string, string) error
+	Query(context.Context, string, string, string, interface{}) error
+	Find(context.Context, string, Query, interface{}) error
+	Stream(context.Context, string, Query, func(func(interface{}) error) error) error
+	Insert(context.Context, string, interface{}) error
+	InsertMany(context.Context, string, []interface{}) (int64, error)
+	Update(context.Context, string, string, map[string]interface{}) error
+	UpdateMany(context.Context, string, Query, map[string]interface{}) (int64, error)
+	Delete(context.Context, string, string) error
+	DeleteMany(context.Context, string, Query) (int64, error)
+	WithTransaction(context.Context, func(context.Context) error) error
+	Migrate(context.Context) error
+	Reindex(context.Context) error
+	Ping(context.Context) error
+	Close(context.Context) error
 }
 
 type MongoDBHelper struct {
 	client *mongo.Client
 	db     *mongo.Database
+
+	transactions_mu      sync.Mutex
+	transactions_checked bool
+	transactions         bool
 }
 
-func NewMongoDatabase() DatabaseHelper {
+func NewMongoDatabase(cfg config.MongoConfig) (DatabaseHelper, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URL")))
-	log.Print(os.Getenv("MONGO_URL"))
+	zap.L().Info("connecting to mongo",
+		zap.String("url", logging.RedactURL(cfg.URL)),
+		zap.String("database", cfg.Database),
+	)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URL).SetMonitor(newTracingMonitor()))
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
-	db := client.Database(os.Getenv("MONGO_DATABASE"))
-	log.Print(os.Getenv("MONGO_DATABASE"))
+	db := client.Database(cfg.Database)
 	return &MongoDBHelper{
 		client: client,
 		db:     db,
-	}
+	}, nil
 }
 
-func (mdb *MongoDBHelper) Query(collectionName, key, value string, data interface{}) error {
+func (mdb *MongoDBHelper) Ping(ctx context.Context) error {
+	return mdb.client.Ping(ctx, readpref.Primary())
+}
 
+func (mdb *MongoDBHelper) Close(ctx context.Context) error {
+	return mdb.client.Disconnect(ctx)
+}
+
+func (mdb *MongoDBHelper) Query(ctx context.Context, collectionName, key, value string, data interface{}) (op_err error) {
+
+	defer observeMongo(collectionName, "query", time.Now(), &op_err)
 	collection := mdb.db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
 
 	value_hex, value_err := primitive.ObjectIDFromHex(value)
 	if value_err != nil {
-		return value_err
+		return ErrInvalidID
 	}
 	result := collection.FindOne(ctx, bson.M{key: value_hex})
 	err := result.Decode(data)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (mdb *MongoDBHelper) FindMulti(collectionName, key, value string, obj interface{}) ([]interface{}, error) {
+// Find decodes the documents matching query into results, a pointer to a
+// slice.
+func (mdb *MongoDBHelper) Find(ctx context.Context, collectionName string, query Query, results interface{}) (op_err error) {
 
+	defer observeMongo(collectionName, "find", time.Now(), &op_err)
 	collection := mdb.db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
 
-	cur, err := collection.Find(ctx, bson.M{key: value})
+	cur, err := collection.Find(ctx, query.filter(), query.findOptions())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return cur.All(ctx, results)
+}
+
+// Stream calls fn for each document matching query as the cursor reaches
+// it, with decode unmarshaling that document. It isn't bound by the query
+// timeout, so large results are only limited by ctx. An error from fn
+// stops the iteration and is returned.
+func (mdb *MongoDBHelper) Stream(ctx context.Context, collectionName string, query Query, fn func(decode func(interface{}) error) error) (op_err error) {
+
+	defer observeMongo(collectionName, "stream", time.Now(), &op_err)
+	collection := mdb.db.Collection(collectionName)
+
+	cur, err := collection.Find(ctx, query.filter(), query.findOptions())
+	if err != nil {
+		return err
 	}
 	defer cur.Close(ctx)
 
-	var container = make([]interface{}, 0)
 	for cur.Next(ctx) {
-
-		model := reflect.New(reflect.TypeOf(obj)).Interface()
-		decode_err := cur.Decode(model)
-		if decode_err != nil {
-			return nil, decode_err
+		if err := fn(cur.Decode); err != nil {
+			return err
 		}
+	}
+	return cur.Err()
+}
 
-		md := reflect.ValueOf(model).Elem().Interface()
-		container = append(container, md)
+func (mdb *MongoDBHelper) Insert(ctx context.Context, collectionName string, data interface{}) (op_err error) {
+	defer observeMongo(collectionName, "insert", time.Now(), &op_err)
+	collection := mdb.db.Collection(collectionName)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	new_user, err := bson.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = collection.InsertOne(ctx, new_user)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
 	}
 
-	return container, nil
+	return err
 }
 
-func (mdb *MongoDBHelper) FindAll(collectionName string, limit string, obj interface{}) ([]interface{}, error) {
+// InsertMany inserts docs in one unordered call and returns how many it
+// inserted. Documents whose key exists already are skipped, the others are
+// still inserted.
+func (mdb *MongoDBHelper) InsertMany(ctx context.Context, collectionName string, docs []interface{}) (inserted int64, op_err error) {
 
+	defer observeMongo(collectionName, "insert_many", time.Now(), &op_err)
+	if len(docs) == 0 {
+		return 0, nil
+	}
 	collection := mdb.db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
-	findOptions := options.Find()
-	find_limit, _ := strconv.ParseInt(limit, 10, 64)
-	findOptions.SetLimit(find_limit)
-	cur, err := collection.Find(ctx, bson.D{{}}, findOptions)
-	if err != nil {
-		return nil, err
+
+	result, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return int64(len(result.InsertedIDs)), nil
 	}
-	defer cur.Close(ctx)
+	var bulk_err mongo.BulkWriteException
+	if !errors.As(err, &bulk_err) || bulk_err.WriteConcernError != nil {
+		return 0, err
+	}
+	for _, write_err := range bulk_err.WriteErrors {
+		if write_err.Code != duplicateKeyCode {
+			return 0, err
+		}
+	}
+	return int64(len(docs) - len(bulk_err.WriteErrors)), nil
+}
 
-	var container = make([]interface{}, 0)
-	for cur.Next(ctx) {
+func (mdb *MongoDBHelper) Update(ctx context.Context, collectionName, id string, fields map[string]interface{}) (op_err error) {
 
-		model := reflect.New(reflect.TypeOf(obj)).Interface()
-		decode_err := cur.Decode(model)
-		if decode_err != nil {
-			return nil, decode_err
-		}
+	defer observeMongo(collectionName, "update", time.Now(), &op_err)
+	collection := mdb.db.Collection(collectionName)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
 
-		md := reflect.ValueOf(model).Elem().Interface()
-		container = append(container, md)
+	id_hex, objid_err := primitive.ObjectIDFromHex(id)
+	if objid_err != nil {
+		return ErrInvalidID
 	}
 
-	return container, nil
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id_hex}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
 
+	return nil
 }
 
-func (mdb *MongoDBHelper) Insert(collectionName string, data interface{}) error {
+// UpdateMany sets fields on every document matching query and returns how
+// many matched. Sort, projection and limits of query don't apply.
+func (mdb *MongoDBHelper) UpdateMany(ctx context.Context, collectionName string, query Query, fields map[string]interface{}) (matched int64, op_err error) {
+
+	defer observeMongo(collectionName, "update_many", time.Now(), &op_err)
 	collection := mdb.db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
-	new_user, err := bson.Marshal(data)
+
+	result, err := collection.UpdateMany(ctx, query.filter(), bson.M{"$set": fields})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = collection.InsertOne(ctx, new_user)
+	return result.MatchedCount, nil
+}
+
+func (mdb *MongoDBHelper) Delete(ctx context.Context, collectionName, postid string) (op_err error) {
 
+	defer observeMongo(collectionName, "delete", time.Now(), &op_err)
+	collection := mdb.db.Collection(collectionName)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	postid_hex, objid_err := primitive.ObjectIDFromHex(postid)
+	if objid_err != nil {
+		return ErrInvalidID
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: postid_hex}})
 	if err != nil {
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
 
-	return err
+	return nil
 }
 
-func (mdb *MongoDBHelper) Delete(collectionName, postid string) error {
+// DeleteMany deletes every document matching query and returns how many
+// it deleted. Sort, projection and limits of query don't apply.
+func (mdb *MongoDBHelper) DeleteMany(ctx context.Context, collectionName string, query Query) (deleted int64, op_err error) {
 
+	defer observeMongo(collectionName, "delete_many", time.Now(), &op_err)
 	collection := mdb.db.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
 
-	postid_hex, objid_err := primitive.ObjectIDFromHex(postid)
-	if objid_err != nil {
-		return objid_err
+	result, err := collection.DeleteMany(ctx, query.filter())
+	if err != nil {
+		return 0, err
 	}
+	return result.DeletedCount, nil
+}
 
-	_, err := collection.DeleteOne(ctx, bson.D{{"_id", postid_hex}})
+// WithTransaction runs fn in a transaction; the DatabaseHelper calls fn
+// makes with the context it is given take part in it. fn may run more than
+// once when the transaction is retried. Standalone servers have no
+// transactions, there fn runs on its own and a warning is logged.
+func (mdb *MongoDBHelper) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
+
+	if !mdb.supportsTransactions(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := mdb.client.StartSession()
 	if err != nil {
 		return err
 	}
+	defer session.EndSession(ctx)
 
-	return nil
+	_, err = session.WithTransaction(ctx, func(sess_ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sess_ctx)
+	})
+	return err
+}
+
+// supportsTransactions asks the server whether it is a replica set member
+// or a mongos, and remembers the answer once it has one.
+func (mdb *MongoDBHelper) supportsTransactions(ctx context.Context) bool {
+	mdb.transactions_mu.Lock()
+	defer mdb.transactions_mu.Unlock()
+	if mdb.transactions_checked {
+		return mdb.transactions
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := mdb.client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+		return false
+	}
+	mdb.transactions_checked = true
+	mdb.transactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !mdb.transactions {
+		logging.FromContext(ctx).Warn("mongo is standalone, writes and their outbox events are not atomic")
+	}
+	return mdb.transactions
 }
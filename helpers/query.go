@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Op compares a field with a value in a Query.
+type Op string
+
+const (
+	Eq  Op = "$eq"
+	Ne  Op = "$ne"
+	Gt  Op = "$gt"
+	Gte Op = "$gte"
+	Lt  Op = "$lt"
+	Lte Op = "$lte"
+	// In takes a slice and matches any of its values.
+	In Op = "$in"
+)
+
+const (
+	Ascending  = 1
+	Descending = -1
+)
+
+type condition struct {
+	field string
+	op    Op
+	value interface{}
+}
+
+// Query selects, orders and trims the documents Find and Stream return.
+// Its methods return a modified copy, so a base query can be shared:
+//
+//	NewQuery().Where("username", Eq, name).Sort("created", Descending).Limit(20)
+//
+// Conditions on an array field match when any element does, as in Mongo.
+type Query struct {
+	conditions []condition
+	sort       bson.D
+	fields     []string
+	limit      int64
+	skip       int64
+}
+
+func NewQuery() Query {
+	return Query{}
+}
+
+// Where adds a condition, all conditions must hold.
+func (query Query) Where(field string, op Op, value interface{}) Query {
+	query.conditions = append(query.conditions[:len(query.conditions):len(query.conditions)], condition{field, op, value})
+	return query
+}
+
+// Sort orders by field, Ascending or Descending. Later calls break ties
+// of earlier ones.
+func (query Query) Sort(field string, order int) Query {
+	query.sort = append(query.sort[:len(query.sort):len(query.sort)], bson.E{Key: field, Value: order})
+	return query
+}
+
+// Select loads only the given fields, and _id. Without it documents are
+// loaded whole.
+func (query Query) Select(fields ...string) Query {
+	query.fields = append(query.fields[:len(query.fields):len(query.fields)], fields...)
+	return query
+}
+
+// Limit caps the number of documents, 0 means no limit.
+func (query Query) Limit(limit int64) Query {
+	query.limit = limit
+	return query
+}
+
+func (query Query) Skip(skip int64) Query {
+	query.skip = skip
+	return query
+}
+
+// filter builds the Mongo filter, with the conditions on one field merged
+// into a single operator document.
+func (query Query) filter() bson.D {
+	filter := bson.D{}
+	positions := make(map[string]int)
+	for _, cond := range query.conditions {
+		i, seen := positions[cond.field]
+		if !seen {
+			i = len(filter)
+			positions[cond.field] = i
+			filter = append(filter, bson.E{Key: cond.field, Value: bson.D{}})
+		}
+		filter[i].Value = append(filter[i].Value.(bson.D), bson.E{Key: string(cond.op), Value: cond.value})
+	}
+	return filter
+}
+
+func (query Query) findOptions() *options.FindOptions {
+	find_options := options.Find()
+	if len(query.sort) > 0 {
+		find_options.SetSort(query.sort)
+	}
+	if len(query.fields) > 0 {
+		projection := bson.D{}
+		for _, field := range query.fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		find_options.SetProjection(projection)
+	}
+	if query.limit != 0 {
+		find_options.SetLimit(query.limit)
+	}
+	if query.skip > 0 {
+		find_options.SetSkip(query.skip)
+	}
+	return find_options
+}
@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"context"
+)
+
+// Repository reads and writes the documents of one collection as values of
+// T, so callers get typed results instead of interface{} values to assert.
+type Repository[T any] struct {
+	db         DatabaseHelper
+	collection string
+}
+
+func NewRepository[T any](db DatabaseHelper, collection string) *Repository[T] {
+	return &Repository[T]{
+		db:         db,
+		collection: collection,
+	}
+}
+
+// Get loads the document with the given hex id.
+func (repo *Repository[T]) Get(ctx context.Context, id string) (T, error) {
+	var doc T
+	err := repo.db.Query(ctx, repo.collection, "_id", id, &doc)
+	return doc, err
+}
+
+func (repo *Repository[T]) Find(ctx context.Context, query Query) ([]T, error) {
+	var docs []T
+	if err := repo.db.Find(ctx, repo.collection, query, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// Stream calls fn with each matching document without loading them all,
+// see DatabaseHelper.Stream.
+func (repo *Repository[T]) Stream(ctx context.Context, query Query, fn func(T) error) error {
+	return repo.db.Stream(ctx, repo.collection, query, func(decode func(interface{}) error) error {
+		var doc T
+		if err := decode(&doc); err != nil {
+			return err
+		}
+		return fn(doc)
+	})
+}
+
+func (repo *Repository[T]) Insert(ctx context.Context, doc T) error {
+	return repo.db.Insert(ctx, repo.collection, doc)
+}
+
+// InsertMany inserts docs, skipping the ones whose key exists already, see
+// DatabaseHelper.InsertMany.
+func (repo *Repository[T]) InsertMany(ctx context.Context, docs []T) (int64, error) {
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = doc
+	}
+	return repo.db.InsertMany(ctx, repo.collection, values)
+}
+
+func (repo *Repository[T]) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return repo.db.Update(ctx, repo.collection, id, fields)
+}
+
+func (repo *Repository[T]) UpdateMany(ctx context.Context, query Query, fields map[string]interface{}) (int64, error) {
+	return repo.db.UpdateMany(ctx, repo.collection, query, fields)
+}
+
+func (repo *Repository[T]) Delete(ctx context.Context, id string) error {
+	return repo.db.Delete(ctx, repo.collection, id)
+}
+
+func (repo *Repository[T]) DeleteMany(ctx context.Context, query Query) (int64, error) {
+	return repo.db.DeleteMany(ctx, repo.collection, query)
+}
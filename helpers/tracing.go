@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"context"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// newTracingMonitor opens a child span for every Mongo command issued with a
+// traced context. Commands without a parent span, like the driver's own
+// heartbeats, are not traced.
+func newTracingMonitor() *event.CommandMonitor {
+	var spans sync.Map
+
+	finish := func(request_id int64, failure string) {
+		value, ok := spans.Load(request_id)
+		if !ok {
+			return
+		}
+		spans.Delete(request_id)
+		span := value.(opentracing.Span)
+		if failure != "" {
+			ext.Error.Set(span, true)
+			span.LogFields(otlog.String("event", "error"), otlog.String("message", failure))
+		}
+		span.Finish()
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			parent := opentracing.SpanFromContext(ctx)
+			if parent == nil {
+				return
+			}
+			span := parent.Tracer().StartSpan("mongo "+evt.CommandName, opentracing.ChildOf(parent.Context()))
+			ext.SpanKindRPCClient.Set(span)
+			ext.DBType.Set(span, "mongo")
+			ext.DBInstance.Set(span, evt.DatabaseName)
+			span.SetTag("db.command", evt.CommandName)
+			spans.Store(evt.RequestID, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			finish(evt.RequestID, "")
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			finish(evt.RequestID, evt.Failure)
+		},
+	}
+}
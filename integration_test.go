@@ -0,0 +1,507 @@
+//go:build integration
+// +build integration
+
+package main
+
+// The integration suite runs the real Mongo and Redis helpers against
+// throwaway mongod and redis-server processes:
+//
+//	go test -tags integration -run Integration .
+//
+// Tests skip when the binaries are not on the PATH.
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/events"
+	"github.com/vinhut/posted/helpers"
+	"github.com/vinhut/posted/models"
+	"github.com/vinhut/posted/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const integrationTimeout = 30 * time.Second
+
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// startProcess runs binary until the returned func is called, skipping the
+// test when binary isn't installed.
+func startProcess(t *testing.T, binary string, args ...string) func() {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		t.Skipf("%s not found, skipping integration test", binary)
+	}
+	cmd := exec.Command(path, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+// startMongo starts a single node replica set, so transactions are real,
+// and returns a helper connected to a fresh database on it and its url.
+func startMongo(t *testing.T) (helpers.DatabaseHelper, string, func()) {
+
+	dbpath, err := ioutil.TempDir("", "posted-mongo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := freePort(t)
+	stop := startProcess(t, "mongod",
+		"--dbpath", dbpath,
+		"--port", port,
+		"--bind_ip", "127.0.0.1",
+		"--replSet", "rs0",
+		"--quiet",
+		"--logpath", dbpath+"/mongod.log",
+	)
+	cleanup := func() {
+		stop()
+		os.RemoveAll(dbpath)
+	}
+
+	url := "mongodb://127.0.0.1:" + port + "/?directConnection=true"
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+	if err := initiateReplicaSet(ctx, url, "127.0.0.1:"+port); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	db, err := helpers.NewMongoDatabase(config.MongoConfig{
+		URL:            url,
+		Database:       "posted_test",
+		ConnectTimeout: integrationTimeout,
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return db, url, func() {
+		db.Close(context.Background())
+		cleanup()
+	}
+}
+
+// initiateReplicaSet waits for mongod to accept connections, makes it the
+// primary of its own replica set and creates the collections up front, as
+// servers before 4.4 can't create them inside a transaction.
+func initiateReplicaSet(ctx context.Context, url, host string) error {
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	admin := client.Database("admin")
+
+	rs_config := bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: host}}}},
+	}
+	for {
+		err = admin.RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: rs_config}}).Err()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for {
+		var hello struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		err = admin.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsMaster {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	db := client.Database("posted_test")
+	for _, name := range []string{"posts", "outbox"} {
+		if err := db.RunCommand(ctx, bson.D{{Key: "create", Value: name}}).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startRedis(t *testing.T) (services.RedisService, func()) {
+
+	port := freePort(t)
+	stop := startProcess(t, "redis-server",
+		"--port", port,
+		"--bind", "127.0.0.1",
+		"--save", "",
+		"--appendonly", "no",
+	)
+
+	cache := services.NewRedisService(config.RedisConfig{Addr: "127.0.0.1:" + port})
+	deadline := time.Now().Add(integrationTimeout)
+	for cache.Ping(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatal("redis-server did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cache, func() {
+		cache.Close()
+		stop()
+	}
+}
+
+func TestMongoHelperIntegration(t *testing.T) {
+
+	db, _, cleanup := startMongo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// posts without an id get one from the server instead of all sharing
+	// the zero id
+	assert.NoError(t, db.Insert(ctx, "posts", models.Post{Username: "alice", Tag: []string{"go"}}))
+	assert.NoError(t, db.Insert(ctx, "posts", models.Post{Username: "alice"}))
+
+	post := models.Post{
+		Postid:   primitive.NewObjectID(),
+		Username: "bob",
+		Caption:  "hello",
+		Tag:      []string{"go", "mongo"},
+		Created:  time.Now().UTC().Truncate(time.Millisecond),
+	}
+	assert.NoError(t, db.Insert(ctx, "posts", post))
+	assert.Equal(t, helpers.ErrConflict, db.Insert(ctx, "posts", post))
+
+	found := models.Post{}
+	assert.NoError(t, db.Query(ctx, "posts", "_id", post.Postid.Hex(), &found))
+	assert.Equal(t, post, found)
+	assert.Equal(t, helpers.ErrInvalidID, db.Query(ctx, "posts", "_id", "bad", &found))
+	assert.Equal(t, helpers.ErrNotFound, db.Query(ctx, "posts", "_id", primitive.NewObjectID().Hex(), &found))
+
+	posts := helpers.NewRepository[models.Post](db, "posts")
+	by_user, err := posts.Find(ctx, helpers.NewQuery().Where("username", helpers.Eq, "alice"))
+	assert.NoError(t, err)
+	assert.Len(t, by_user, 2)
+	by_tag, err := posts.Find(ctx, helpers.NewQuery().Where("tag", helpers.Eq, "go"))
+	assert.NoError(t, err)
+	assert.Len(t, by_tag, 2)
+	by_name, err := posts.Find(ctx, helpers.NewQuery().Where("username", helpers.In, []string{"bob", "carol"}))
+	assert.NoError(t, err)
+	assert.Len(t, by_name, 1)
+
+	for limit, expected := range map[int64]int{0: 3, 1: 1, 2: 2, -2: 2, 10: 3} {
+		all, err := posts.Find(ctx, helpers.NewQuery().Limit(limit))
+		assert.NoError(t, err)
+		assert.Len(t, all, expected, "limit %d", limit)
+	}
+	oldest, err := posts.Find(ctx, helpers.NewQuery().Sort("_id", helpers.Ascending).Limit(2))
+	assert.NoError(t, err)
+	if assert.Len(t, oldest, 2) {
+		assert.True(t, oldest[0].Postid.Hex() < oldest[1].Postid.Hex())
+	}
+
+	// conditions on one field merge into one range
+	in_range, err := posts.Find(ctx, helpers.NewQuery().
+		Where("created", helpers.Gt, post.Created.Add(-time.Second)).
+		Where("created", helpers.Lt, post.Created.Add(time.Second)))
+	assert.NoError(t, err)
+	assert.Len(t, in_range, 1)
+
+	newest, err := posts.Find(ctx, helpers.NewQuery().Sort("created", helpers.Descending).Limit(1).Select("_id"))
+	assert.NoError(t, err)
+	if assert.Len(t, newest, 1) {
+		assert.Equal(t, models.Post{Postid: post.Postid}, newest[0])
+	}
+
+	var streamed []string
+	assert.NoError(t, posts.Stream(ctx, helpers.NewQuery().Sort("username", helpers.Ascending), func(post models.Post) error {
+		streamed = append(streamed, post.Username)
+		return nil
+	}))
+	assert.Equal(t, []string{"alice", "alice", "bob"}, streamed)
+
+	assert.NoError(t, db.Update(ctx, "posts", post.Postid.Hex(), map[string]interface{}{"caption": "edited"}))
+	assert.NoError(t, db.Query(ctx, "posts", "_id", post.Postid.Hex(), &found))
+	assert.Equal(t, "edited", found.Caption)
+	assert.Equal(t, "bob", found.Username)
+	assert.Equal(t, helpers.ErrNotFound, db.Update(ctx, "posts", primitive.NewObjectID().Hex(), map[string]interface{}{"caption": "x"}))
+	assert.Equal(t, helpers.ErrInvalidID, db.Update(ctx, "posts", "bad", map[string]interface{}{"caption": "x"}))
+
+	assert.NoError(t, db.Delete(ctx, "posts", post.Postid.Hex()))
+	assert.Equal(t, helpers.ErrNotFound, db.Delete(ctx, "posts", post.Postid.Hex()))
+	assert.Equal(t, helpers.ErrInvalidID, db.Delete(ctx, "posts", "bad"))
+
+	// the taken id is skipped, the posts around it still go in
+	imported, err := posts.InsertMany(ctx, []models.Post{
+		{Postid: primitive.NewObjectID(), Username: "carol"},
+		{Postid: by_user[0].Postid, Username: "carol"},
+		{Postid: primitive.NewObjectID(), Username: "carol"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), imported)
+	imported, err = posts.InsertMany(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), imported)
+
+}
+
+func TestMongoTransactionIntegration(t *testing.T) {
+
+	db, _, cleanup := startMongo(t)
+	defer cleanup()
+	ctx := context.Background()
+	postdb := models.NewPostDatabase(db)
+
+	post := &models.Post{Postid: primitive.NewObjectID(), Uid: "1", Username: "alice"}
+	created, err := postdb.Create(ctx, post)
+	assert.True(t, created)
+	assert.NoError(t, err)
+
+	// the duplicate insert aborts the transaction, so no second event
+	_, err = postdb.Create(ctx, post)
+	assert.Equal(t, helpers.ErrConflict, err)
+	_, err = postdb.Delete(ctx, primitive.NewObjectID().Hex())
+	assert.Equal(t, helpers.ErrNotFound, err)
+
+	deleted, err := postdb.Delete(ctx, post.Postid.Hex())
+	assert.True(t, deleted)
+	assert.NoError(t, err)
+
+	publisher := events.NewMemoryPublisher()
+	relay := events.NewRelay(models.NewOutbox(db), publisher, time.Second, 10)
+	relayed, err := relay.RelayOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, relayed)
+	var types []string
+	for _, event := range publisher.Events() {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{events.PostCreated, events.PostDeleted}, types)
+
+	pending, err := models.NewOutbox(db).Pending(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+}
+
+func TestRedisIntegration(t *testing.T) {
+
+	cache, cleanup := startRedis(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	_, err := cache.Get(ctx, "missing")
+	assert.Equal(t, redis.Nil, err)
+
+	assert.NoError(t, cache.Set(ctx, "key", "value"))
+	value, err := cache.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	assert.NoError(t, cache.Delete(ctx, "key"))
+	_, err = cache.Get(ctx, "key")
+	assert.Equal(t, redis.Nil, err)
+	assert.NoError(t, cache.Delete(ctx, "key"))
+
+	assert.NoError(t, cache.SetWithTTL(ctx, "short", "value", 100*time.Millisecond))
+	value, err = cache.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	_, ttl, err := cache.GetWithTTL(ctx, "short")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 100*time.Millisecond)
+	cache.Set(ctx, "key", "value")
+	_, ttl, _ = cache.GetWithTTL(ctx, "key")
+	assert.Equal(t, time.Duration(0), ttl)
+	_, _, err = cache.GetWithTTL(ctx, "missing")
+	assert.Equal(t, redis.Nil, err)
+	time.Sleep(300 * time.Millisecond)
+	_, err = cache.Get(ctx, "short")
+	assert.Equal(t, redis.Nil, err)
+
+	sub_ctx, unsubscribe := context.WithCancel(ctx)
+	messages, err := cache.Subscribe(sub_ctx, "channel")
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Publish(ctx, "channel", "message"))
+	select {
+	case message := <-messages:
+		assert.Equal(t, "message", message)
+	case <-time.After(time.Second):
+		t.Error("published message was not delivered")
+	}
+	unsubscribe()
+	for range messages {
+	}
+
+	cache.Set(ctx, "scan:1", "value")
+	cache.Set(ctx, "scan:2", "value")
+	cache.Set(ctx, "other", "value")
+	var scanned []string
+	assert.NoError(t, cache.Scan(ctx, "scan:*", func(key string) error {
+		scanned = append(scanned, key)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"scan:1", "scan:2"}, scanned)
+
+}
+
+func TestPostServiceIntegration(t *testing.T) {
+
+	db, _, cleanup_mongo := startMongo(t)
+	defer cleanup_mongo()
+	cache, cleanup_redis := startRedis(t)
+	defer cleanup_redis()
+
+	auth := services.NewFakeAuthService(map[string]services.FakeUser{
+		"alice-token": {Uid: "1", Username: "alice"},
+	})
+	router := setupRouter(models.NewPostDatabase(db), auth, cache)
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Cookie", "token=alice-token;")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 200, send("POST", "/"+SERVICE_NAME+"/post", `{"post_caption": "hello"}`).Code)
+
+	var list struct {
+		Results []string `json:"results"`
+	}
+	json.Unmarshal(send("GET", "/"+SERVICE_NAME+"/allpost?range=10", "").Body.Bytes(), &list)
+	if !assert.Len(t, list.Results, 1) {
+		return
+	}
+	postid := list.Results[0]
+	target := "/" + SERVICE_NAME + "/post?postid=" + postid
+
+	var post models.Post
+	json.Unmarshal(send("GET", target, "").Body.Bytes(), &post)
+	assert.Equal(t, "hello", post.Caption)
+	cached, err := cache.Get(context.Background(), postid)
+	assert.NoError(t, err)
+	assert.Contains(t, cached, "hello")
+
+	assert.Equal(t, 200, send("PUT", target, `{"post_caption": "edited"}`).Code)
+	_, err = cache.Get(context.Background(), postid)
+	assert.Equal(t, redis.Nil, err)
+	json.Unmarshal(send("GET", target, "").Body.Bytes(), &post)
+	assert.Equal(t, "edited", post.Caption)
+
+	assert.Equal(t, 200, send("DELETE", target, "").Code)
+	assert.Equal(t, 404, send("GET", target, "").Code)
+
+}
+
+func TestMigrationsIntegration(t *testing.T) {
+
+	db, url, cleanup := startMongo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	legacy := models.Post{Postid: primitive.NewObjectID(), Username: "alice"}
+	assert.NoError(t, db.Insert(ctx, "posts", legacy))
+
+	assert.NoError(t, db.Migrate(ctx))
+	// a second run has nothing left to do
+	assert.NoError(t, db.Migrate(ctx))
+
+	var migrated bson.M
+	assert.NoError(t, db.Query(ctx, "posts", "_id", legacy.Postid.Hex(), &migrated))
+	assert.Equal(t, bson.A{}, migrated["tag"])
+
+	var applied []bson.M
+	err := db.Find(ctx, "schema_migrations", helpers.NewQuery(), &applied)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(helpers.Migrations))
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	posts := client.Database("posted_test").Collection("posts")
+	indexNames := func() map[string]bool {
+		cur, err := posts.Indexes().List(ctx)
+		assert.NoError(t, err)
+		var indexes []bson.M
+		assert.NoError(t, cur.All(ctx, &indexes))
+		names := make(map[string]bool)
+		for _, index := range indexes {
+			names[index["name"].(string)] = true
+		}
+		return names
+	}
+	names := indexNames()
+	for _, index := range helpers.Indexes {
+		if index.Collection == "posts" {
+			assert.True(t, names[index.Name], "missing index %s", index.Name)
+		}
+	}
+
+	// reindex drops what isn't declared and fixes changed keys
+	_, err = posts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "caption", Value: 1}}, Options: options.Index().SetName("caption_1")},
+	})
+	assert.NoError(t, err)
+	_, err = posts.Indexes().DropOne(ctx, "uid_1")
+	assert.NoError(t, err)
+	_, err = posts.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "uid", Value: -1}}, Options: options.Index().SetName("uid_1")})
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Reindex(ctx))
+	names = indexNames()
+	assert.False(t, names["caption_1"])
+	assert.True(t, names["uid_1"])
+	assert.True(t, names["caption_text_tag_text"])
+	cur, err := posts.Indexes().List(ctx)
+	assert.NoError(t, err)
+	var listed []struct {
+		Name string `bson:"name"`
+		Keys bson.D `bson:"key"`
+	}
+	assert.NoError(t, cur.All(ctx, &listed))
+	for _, index := range listed {
+		if index.Name == "uid_1" {
+			assert.EqualValues(t, 1, index.Keys[0].Value)
+		}
+	}
+
+}
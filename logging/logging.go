@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"net/url"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/vinhut/posted/propagation"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New builds a JSON logger writing to stderr at the given level
+// (debug, info, warn or error) and installs it as the global zap logger.
+func New(level string) (*zap.Logger, error) {
+	var zap_level zapcore.Level
+	if err := zap_level.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(zap_level)
+	cfg.EncoderConfig.TimeKey = "time"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.Sampling = nil
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	zap.ReplaceGlobals(logger)
+	return logger, nil
+}
+
+// TraceID returns the id of the trace the context's span belongs to, or ""
+// when the context isn't traced by Jaeger.
+func TraceID(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	if span_ctx, ok := span.Context().(jaeger.SpanContext); ok {
+		return span_ctx.TraceID().String()
+	}
+	return ""
+}
+
+// FromContext returns the global logger annotated with the request and
+// trace ids carried by ctx. Everything a request runs logs through it so
+// every line can be tied back to its request, deletion jobs included: they
+// keep the ids of the request that started them. What runs outside a
+// request logs through zap.L(): startup and shutdown, the commands, the
+// outbox relay, cache eviction subscriptions and deletion jobs resumed
+// from the database.
+func FromContext(ctx context.Context) *zap.Logger {
+	logger := zap.L()
+	if request_id := propagation.RequestID(ctx); request_id != "" {
+		logger = logger.With(zap.String("request_id", request_id))
+	}
+	if trace_id := TraceID(ctx); trace_id != "" {
+		logger = logger.With(zap.String("trace_id", trace_id))
+	}
+	return logger
+}
+
+// RedactURL masks the password of a connection string so it can be logged.
+func RedactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable url>"
+	}
+	if parsed.User != nil {
+		if _, has_password := parsed.User.Password(); has_password {
+			parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+		}
+	}
+	return parsed.String()
+}
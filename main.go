@@ -3,280 +3,363 @@ package main
 import (
 	"github.com/gin-gonic/gin"
 	opentracing "github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
-	jaeger "github.com/uber/jaeger-client-go"
-	jaegercfg "github.com/uber/jaeger-client-go/config"
-	jaegerlog "github.com/uber/jaeger-client-go/log"
-	transport "github.com/uber/jaeger-client-go/transport/zipkin"
-	"github.com/uber/jaeger-client-go/zipkin"
-	"github.com/uber/jaeger-lib/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/events"
 	"github.com/vinhut/posted/helpers"
+	"github.com/vinhut/posted/logging"
 	"github.com/vinhut/posted/models"
 	"github.com/vinhut/posted/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var SERVICE_NAME = "post-service"
 
-func checkUser(authservice services.AuthService, token string) (map[string]interface{}, error) {
+const jsonContentType = "application/json; charset=utf-8"
+
+// authorSyncBatchSize is how many posts internal/user-updated rewrites
+// per database call.
+const authorSyncBatchSize = 500
+
+func checkUser(ctx context.Context, authservice services.AuthService, token string) (map[string]interface{}, error) {
 
 	var data map[string]interface{}
-	user_data, auth_error := authservice.Check(SERVICE_NAME, token)
+	user_data, auth_error := authservice.Check(ctx, SERVICE_NAME, token)
 	if auth_error != nil {
 		return data, auth_error
 	}
 
 	if err := json.Unmarshal([]byte(user_data), &data); err != nil {
-		return data, err
+		return data, fmt.Errorf("%w: malformed user data", services.ErrUnauthorized)
 	}
 
 	return data, nil
 
 }
 
-func setupRouter(postdb models.PostDatabase, authservice services.AuthService, cache services.RedisService) *gin.Engine {
+// authenticate checks the token cookie of the request against the auth
+// service and returns the caller's user data.
+func authenticate(ctx context.Context, c *gin.Context, authservice services.AuthService) (map[string]interface{}, error) {
+	token, cookie_err := c.Cookie("token")
+	if cookie_err != nil {
+		return nil, services.ErrUnauthorized
+	}
+	return checkUser(ctx, authservice, token)
+}
 
-	var JAEGER_COLLECTOR_ENDPOINT = os.Getenv("JAEGER_COLLECTOR_ENDPOINT")
-	zipkinPropagator := zipkin.NewZipkinB3HTTPHeaderPropagator()
-	trsport, _ := transport.NewHTTPTransport(
-		JAEGER_COLLECTOR_ENDPOINT,
-		transport.HTTPLogger(jaeger.StdLogger),
-	)
-	cfg := jaegercfg.Configuration{
-		ServiceName: "post-service",
-		Sampler: &jaegercfg.SamplerConfig{
-			Type:  "const",
-			Param: 1,
-		},
-		Reporter: &jaegercfg.ReporterConfig{
-			LogSpans:          true,
-			CollectorEndpoint: JAEGER_COLLECTOR_ENDPOINT,
-		},
+// userField reads a string field of the auth service's user data, missing
+// or mistyped fields read as empty.
+func userField(user_data map[string]interface{}, field string) string {
+	value, _ := user_data[field].(string)
+	return value
+}
+
+// routerOptions are the optional parts of the router.
+type routerOptions struct {
+	deletions    *userDeletionRunner
+	posts        *postCache
+	serviceToken string
+}
+
+type routerOption func(*routerOptions)
+
+// withUserDeletions serves account deletion. Without it, deleting a user
+// answers 501.
+func withUserDeletions(runner *userDeletionRunner) routerOption {
+	return func(options *routerOptions) {
+		options.deletions = runner
 	}
-	jLogger := jaegerlog.StdLogger
-	jMetricsFactory := metrics.NullFactory
-	cfg.InitGlobalTracer(
-		"post-service",
-		jaegercfg.Logger(jLogger),
-		jaegercfg.Metrics(jMetricsFactory),
-		jaegercfg.Injector(opentracing.HTTPHeaders, zipkinPropagator),
-		jaegercfg.Extractor(opentracing.HTTPHeaders, zipkinPropagator),
-		jaegercfg.ZipkinSharedRPCSpan(true),
-		jaegercfg.Reporter(jaeger.NewRemoteReporter(trsport)),
-	)
+}
+
+// withPostCache reads posts through posts instead of a cache with the
+// default settings, so the gRPC server and background jobs can share it.
+func withPostCache(posts *postCache) routerOption {
+	return func(options *routerOptions) {
+		options.posts = posts
+	}
+}
+
+// withServiceToken is the token the account and bulk routes under
+// internal/user and internal/posts ask other services for. Without it they
+// refuse every request.
+func withServiceToken(token string) routerOption {
+	return func(options *routerOptions) {
+		options.serviceToken = token
+	}
+}
+
+func setupRouter(postdb models.PostDatabase, authservice services.AuthService, cache services.RedisService, opts ...routerOption) *gin.Engine {
 	tracer := opentracing.GlobalTracer()
+	options := &routerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	posts := options.posts
+	if posts == nil {
+		posts = newPostCache(cache, postdb, config.Default().PostCache)
+	}
+
+	router := gin.New()
+	router.Use(
+		requestIDMiddleware(),
+		metricsMiddleware(),
+		tracingMiddleware(tracer),
+		accessLogMiddleware(),
+		errorMiddleware(),
+		recoveryMiddleware(),
+	)
 
-	router := gin.Default()
+	router.NoRoute(func(c *gin.Context) {
+		abortWithError(c, errRouteNotFound)
+	})
 
 	router.GET("/ping", func(c *gin.Context) {
 		c.String(200, "OK")
 	})
 
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	router.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	router.GET("/readyz", readinessHandler(map[string]dependencyCheck{
+		"mongo": postdb.Ping,
+		"redis": cache.Ping,
+		"auth":  authservice.Ping,
+	}))
+
 	router.GET(SERVICE_NAME+"/post", func(c *gin.Context) {
 
-		span := tracer.StartSpan("get post")
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "get post")
+		defer span.Finish()
 
-		value, cookie_err := c.Cookie("token")
 		post_id, _ := c.GetQuery("postid")
-		if cookie_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
-			return
-		}
 
-		cspan := tracer.StartSpan("check user",
-			opentracing.ChildOf(span.Context()),
-		)
-		_, check_err := checkUser(authservice, value)
+		cspan, cctx := opentracing.StartSpanFromContextWithTracer(ctx, tracer, "check user")
+		_, check_err := authenticate(cctx, c, authservice)
 		cspan.Finish()
 		if check_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
+			abortWithError(c, check_err)
 			return
 		}
 
-		cspan = tracer.StartSpan("get post from cache",
-			opentracing.ChildOf(span.Context()),
-		)
-		entry, cache_err := cache.Get(post_id)
-		cspan.Finish()
-		if cache_err == nil {
-			span.Finish()
-			c.String(200, entry)
+		post_json, get_err := posts.Get(ctx, post_id)
+		if get_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, get_err))
 			return
 		}
-
-		result := &models.Post{}
-		cspan = tracer.StartSpan("find post by id",
-			opentracing.ChildOf(span.Context()),
-		)
-		find_err := postdb.Find("_id", post_id, result)
-		cspan.Finish()
-		if find_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(404, gin.H{"reason": "post not found"})
-			return
-		}
-
-		post_json, json_err := json.Marshal(result)
-		if json_err != nil {
-			panic("marshal json fail")
-		}
-
-		cspan = tracer.StartSpan("store post in cache",
-			opentracing.ChildOf(span.Context()),
-		)
-		cache.Set(post_id, string(post_json))
-		cspan.Finish()
-		span.Finish()
-		c.String(200, string(post_json))
+		c.Data(200, jsonContentType, post_json)
 
 	})
 
 	router.POST(SERVICE_NAME+"/post", func(c *gin.Context) {
 
-		span := tracer.StartSpan("create post")
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "create post")
+		defer span.Finish()
 
-		value, cookie_err := c.Cookie("token")
-		if cookie_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
-			return
-		}
-		user_data, check_err := checkUser(authservice, value)
+		user_data, check_err := authenticate(ctx, c, authservice)
 		if check_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
+			abortWithError(c, check_err)
 			return
 		}
-		img_url := c.PostForm("img_url")
-		post_caption := c.PostForm("post_caption")
-		verified, _ := strconv.ParseBool(user_data["verified"].(string))
-		tags := c.PostForm("tags")
-		post_tags := make([]string, 1)
-		if tags != "" {
-			post_tags = strings.Split(tags, ",")
+		req := &createPostRequest{}
+		if bind_err := req.bind(c); bind_err != nil {
+			abortWithError(c, bind_err)
+			return
 		}
+		verified, _ := strconv.ParseBool(userField(user_data, "verified"))
 		new_post := &models.Post{
 
 			Postid:       primitive.NewObjectIDFromTimestamp(time.Now()),
-			Uid:          user_data["uid"].(string),
-			Username:     user_data["username"].(string),
-			Screenname:   user_data["screenname"].(string),
-			Avatarurl:    user_data["avatarurl"].(string),
+			Uid:          userField(user_data, "uid"),
+			Username:     userField(user_data, "username"),
+			Screenname:   userField(user_data, "screenname"),
+			Avatarurl:    userField(user_data, "avatarurl"),
 			Verified:     verified,
-			Imageurl:     img_url,
-			Caption:      post_caption,
+			Imageurl:     req.ImgURL,
+			Caption:      req.Caption,
 			Likecount:    0,
 			Private:      false,
 			Commentcount: 0,
 			Viewcount:    0,
 			Created:      time.Now(),
-			Tag:          post_tags,
+			Tag:          req.Tags,
 		}
 
-		_, create_error := postdb.Create(new_post)
+		_, create_error := postdb.Create(ctx, new_post)
 		if create_error != nil {
-			span.Finish()
-			panic(create_error.Error())
+			abortWithError(c, create_error)
+			return
 		}
 		c.String(200, "ok")
-		span.Finish()
 
 	})
 
-	router.DELETE(SERVICE_NAME+"/post", func(c *gin.Context) {
+	router.PUT(SERVICE_NAME+"/post", func(c *gin.Context) {
 
-		span := tracer.StartSpan("delete post")
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "update post")
+		defer span.Finish()
 
-		value, cookie_err := c.Cookie("token")
 		post_id, _ := c.GetQuery("postid")
-		if cookie_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
+		user_data, check_err := authenticate(ctx, c, authservice)
+		if check_err != nil {
+			abortWithError(c, check_err)
 			return
 		}
-		_, check_err := checkUser(authservice, value)
+
+		req := &updatePostRequest{}
+		if bind_err := req.bind(c); bind_err != nil {
+			abortWithError(c, bind_err)
+			return
+		}
+
+		post := &models.Post{}
+		if find_err := postdb.Find(ctx, "_id", post_id, post); find_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, find_err))
+			return
+		}
+		if owner_err := post.CheckOwner(userField(user_data, "uid")); owner_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, owner_err))
+			return
+		}
+
+		_, update_err := postdb.Update(ctx, post_id, models.PostUpdate{
+			Imageurl: req.ImgURL,
+			Caption:  req.Caption,
+			Tag:      req.Tags,
+		})
+		if update_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, update_err))
+			return
+		}
+
+		posts.Invalidate(ctx, post_id)
+		c.String(200, "updated")
+
+	})
+
+	router.DELETE(SERVICE_NAME+"/post", func(c *gin.Context) {
+
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "delete post")
+		defer span.Finish()
+
+		post_id, _ := c.GetQuery("postid")
+		user_data, check_err := authenticate(ctx, c, authservice)
 		if check_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
+			abortWithError(c, check_err)
 			return
 		}
 
-		_, delete_err := postdb.Delete(post_id)
+		post := &models.Post{}
+		if find_err := postdb.Find(ctx, "_id", post_id, post); find_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, find_err))
+			return
+		}
+		if owner_err := post.CheckOwner(userField(user_data, "uid")); owner_err != nil {
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, owner_err))
+			return
+		}
+
+		_, delete_err := postdb.Delete(ctx, post_id)
 		if delete_err != nil {
-			panic(delete_err.Error())
+			abortWithError(c, fmt.Errorf("post %s: %w", post_id, delete_err))
+			return
 		}
 
-		cache.Delete(post_id)
+		posts.Invalidate(ctx, post_id)
 		c.String(200, "deleted")
-		span.Finish()
 
 	})
 
 	router.GET(SERVICE_NAME+"/allpost", func(c *gin.Context) {
 
-		spanCtx, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(c.Request.Header))
-		span := tracer.StartSpan("get all post", ext.RPCServerOption(spanCtx))
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "get all post")
+		defer span.Finish()
 
 		feed_range, query_exist := c.GetQuery("range")
 		if query_exist == false {
 			feed_range = "8"
 		}
-		result, findall_err := postdb.FindAll(feed_range)
+		result, findall_err := postdb.FindAll(ctx, feed_range)
 		if findall_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(404, gin.H{"reason": "not found"})
+			abortWithError(c, findall_err)
 			return
 		}
 
 		allid_json, json_err := json.Marshal(result)
 		if json_err != nil {
-			panic("marshal json fail")
+			abortWithError(c, json_err)
+			return
 		}
-		c.String(200, `{ "results": `+string(allid_json)+`}`)
-		span.Finish()
+		c.Data(200, jsonContentType, []byte(`{ "results": `+string(allid_json)+`}`))
 
 	})
 
 	router.GET(SERVICE_NAME+"/user/:name", func(c *gin.Context) {
 
-		span := tracer.StartSpan("get post")
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "get post")
+		defer span.Finish()
 
-		value, cookie_err := c.Cookie("token")
 		name := c.Param("name")
-
-		if cookie_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
-			return
-		}
-		_, check_err := checkUser(authservice, value)
+		_, check_err := authenticate(ctx, c, authservice)
 		if check_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(401, gin.H{"reason": "unauthorized"})
+			abortWithError(c, check_err)
 			return
 		}
 
-		result, findall_err := postdb.FindMulti("username", name)
+		result, findall_err := postdb.FindMulti(ctx, "username", name)
 		if findall_err != nil {
-			span.Finish()
-			c.AbortWithStatusJSON(404, gin.H{"reason": "not found"})
+			abortWithError(c, findall_err)
 			return
 		}
 
 		allid_json, json_err := json.Marshal(result)
 		if json_err != nil {
-			panic("marshal json fail")
+			abortWithError(c, json_err)
+			return
 		}
-		c.String(200, `{ "results": `+string(allid_json)+`}`)
-		span.Finish()
+		c.Data(200, jsonContentType, []byte(`{ "results": `+string(allid_json)+`}`))
+
+	})
+
+	// Internal token endpoint
+
+	router.POST("internal/token/revoke", func(c *gin.Context) {
+
+		revoker, can_revoke := authservice.(services.TokenRevoker)
+		if !can_revoke {
+			abortWithError(c, fmt.Errorf("%w: token cache disabled", errNotImplemented))
+			return
+		}
+
+		token := c.PostForm("token")
+		if token == "" {
+			abortWithError(c, fmt.Errorf("%w: missing token", errBadRequest))
+			return
+		}
+
+		if revoke_err := revoker.Revoke(c.Request.Context(), SERVICE_NAME, token); revoke_err != nil {
+			abortWithError(c, revoke_err)
+			return
+		}
+		c.String(200, "revoked")
 
 	})
 
@@ -284,65 +367,406 @@ func setupRouter(postdb models.PostDatabase, authservice services.AuthService, c
 
 	router.POST("internal/post", func(c *gin.Context) {
 
-		span := tracer.StartSpan("internal create post")
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "internal create post")
+		defer span.Finish()
 
-		img_url := c.PostForm("img_url")
-		post_caption := c.PostForm("post_caption")
-		uid := c.PostForm("uid")
-		username := c.PostForm("username")
-		screenname := c.PostForm("screenname")
-		avatarurl := c.PostForm("avatarurl")
-		tags := c.PostForm("tags")
-		post_tags := make([]string, 1)
-		if tags != "" {
-			post_tags = strings.Split(tags, ",")
+		req := &internalCreatePostRequest{}
+		if bind_err := req.bind(c); bind_err != nil {
+			abortWithError(c, bind_err)
+			return
 		}
 
 		new_post := &models.Post{
 
 			Postid:       primitive.NewObjectIDFromTimestamp(time.Now()),
-			Uid:          uid,
-			Username:     username,
-			Screenname:   screenname,
-			Avatarurl:    avatarurl,
+			Uid:          req.Uid,
+			Username:     req.Username,
+			Screenname:   req.Screenname,
+			Avatarurl:    req.Avatarurl,
 			Verified:     false,
-			Imageurl:     img_url,
-			Caption:      post_caption,
+			Imageurl:     req.ImgURL,
+			Caption:      req.Caption,
 			Likecount:    0,
 			Private:      false,
 			Commentcount: 0,
 			Viewcount:    0,
 			Created:      time.Now(),
-			Tag:          post_tags,
+			Tag:          req.Tags,
 		}
 
-		_, create_error := postdb.Create(new_post)
-		if create_error == nil {
-			c.String(200, "ok")
-			span.Finish()
-		} else {
-			c.String(503, "error")
-			span.Finish()
-			panic("failed create post")
+		_, create_error := postdb.Create(ctx, new_post)
+		if create_error != nil {
+			abortWithError(c, create_error)
+			return
 		}
+		c.String(200, "ok")
 
 	})
 
+	router.POST("internal/user-updated", func(c *gin.Context) {
+
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "sync author profile")
+		defer span.Finish()
+
+		req := &userUpdatedRequest{}
+		if bind_err := req.bind(c); bind_err != nil {
+			abortWithError(c, bind_err)
+			return
+		}
+
+		update := models.AuthorUpdate{
+			Username:   req.Username,
+			Screenname: req.Screenname,
+			Avatarurl:  req.Avatarurl,
+			Verified:   req.Verified,
+		}
+		// As with user deletion, a failed invalidation doesn't stop the
+		// sync: the stale entries run out with the post cache TTL.
+		updated, update_err := postdb.UpdateAuthor(ctx, req.Uid, update, authorSyncBatchSize, func(postids []string) error {
+			for _, postid := range postids {
+				if cache_err := posts.Invalidate(ctx, postid); cache_err != nil {
+					logging.FromContext(ctx).Warn("invalidating updated post", zap.String("postid", postid), zap.Error(cache_err))
+				}
+			}
+			return nil
+		})
+		span.SetTag("posts.updated", updated)
+		if update_err != nil {
+			abortWithError(c, update_err)
+			return
+		}
+		c.JSON(200, gin.H{"updated": updated})
+
+	})
+
+	// Account deletion and export, for other services only. The service
+	// keeps no likes or other records per user besides their posts.
+
+	users := router.Group("internal/user", requireServiceToken(options.serviceToken))
+
+	users.DELETE(":uid", func(c *gin.Context) {
+
+		if options.deletions == nil {
+			abortWithError(c, fmt.Errorf("%w: user deletion is not enabled", errNotImplemented))
+			return
+		}
+
+		mode := c.DefaultQuery("mode", models.DeletionModeDelete)
+		if mode != models.DeletionModeDelete && mode != models.DeletionModeAnonymize {
+			abortWithError(c, fmt.Errorf("%w: mode must be %s or %s", errBadRequest, models.DeletionModeDelete, models.DeletionModeAnonymize))
+			return
+		}
+
+		job, start_err := options.deletions.Start(c.Request.Context(), c.Param("uid"), mode)
+		if start_err != nil {
+			abortWithError(c, start_err)
+			return
+		}
+		c.JSON(202, job)
+
+	})
+
+	users.GET(":uid/deletion", func(c *gin.Context) {
+
+		if options.deletions == nil {
+			abortWithError(c, fmt.Errorf("%w: user deletion is not enabled", errNotImplemented))
+			return
+		}
+
+		job, status_err := options.deletions.Status(c.Request.Context(), c.Param("uid"))
+		if status_err != nil {
+			abortWithError(c, fmt.Errorf("deletion of user %s: %w", c.Param("uid"), status_err))
+			return
+		}
+		c.JSON(200, job)
+
+	})
+
+	users.GET(":uid/export", func(c *gin.Context) {
+
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "export user posts")
+		defer span.Finish()
+
+		uid := c.Param("uid")
+		serveExport(ctx, c, postdb, models.PostFilter{Uid: uid}, "posts-"+uid)
+
+	})
+
+	// Bulk import and export, for other services only
+
+	bulk := router.Group("internal/posts", requireServiceToken(options.serviceToken))
+
+	bulk.GET("export", func(c *gin.Context) {
+
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "export posts")
+		defer span.Finish()
+
+		filter, filter_err := parsePostFilter(c.Query("uid"), c.Query("from"), c.Query("to"))
+		if filter_err != nil {
+			abortWithError(c, filter_err)
+			return
+		}
+		serveExport(ctx, c, postdb, filter, "posts")
+
+	})
+
+	bulk.POST("import", func(c *gin.Context) {
+
+		span, ctx := opentracing.StartSpanFromContextWithTracer(c.Request.Context(), tracer, "import posts")
+		defer span.Finish()
+
+		dry_run, parse_err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+		if parse_err != nil {
+			abortWithError(c, fmt.Errorf("%w: dry_run must be true or false", errBadRequest))
+			return
+		}
+
+		result, import_err := importPosts(ctx, c.Request.Body, postdb, importOptions{
+			BatchSize: importBatchSize,
+			DryRun:    dry_run,
+		})
+		span.SetTag("posts.imported", result.Imported)
+		if import_err != nil {
+			abortWithError(c, import_err)
+			return
+		}
+		c.JSON(200, result)
+
+	})
+
+	router.GET("/openapi.json", openAPIHandler(router))
+
 	return router
 
 }
 
+// migrate applies pending schema migrations and creates missing indexes.
+func migrate(mongo_layer helpers.DatabaseHelper, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return mongo_layer.Migrate(ctx)
+}
+
 func main() {
 
-	mongo_layer := helpers.NewMongoDatabase()
-	postdb := models.NewPostDatabase(mongo_layer)
-	authservice := services.NewUserAuthService()
-	redis_service := services.NewRedisService()
+	dev := flag.Bool("dev", false, "keep posts, cache and users in memory instead of Mongo, Redis and the auth service, serve only")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	load_config := config.Load
+	if *dev {
+		load_config = config.LoadDev
+	}
+	cfg, cfg_err := load_config(os.Getenv("CONFIG_FILE"))
+	logger, log_err := logging.New(cfg.LogLevel)
+	if log_err != nil {
+		logger, _ = logging.New("info")
+	}
+	defer logger.Sync()
+	if cfg_err != nil {
+		logger.Fatal("invalid configuration", zap.Error(cfg_err))
+	}
+	gin.SetMode(gin.ReleaseMode)
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, known := findCommand(name)
+	if !known {
+		flag.Usage()
+		logger.Fatal("unknown command", zap.String("command", name))
+	}
+	if dev_err := checkDevCommand(cfg, name); dev_err != nil {
+		logger.Fatal("invalid command", zap.Error(dev_err))
+	}
+
+	env, env_err := openCommandEnv(cfg)
+	if env_err != nil {
+		logger.Fatal("mongo connect failed", zap.Error(env_err))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	run_err := cmd.run(ctx, env, args)
+	stop()
+
+	close_ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	env.close(close_ctx)
+	if run_err != nil && !errors.Is(run_err, flag.ErrHelp) {
+		logger.Fatal(name+" failed", zap.Error(run_err))
+	}
+
+}
+
+// runServe is the serve command: it runs the HTTP and gRPC servers until
+// ctx is done.
+func runServe(ctx context.Context, env *commandEnv, args []string) error {
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := env.cfg
+	logger := zap.L()
+
+	var (
+		tracer_closer io.Closer
+		authservice   services.AuthService
+		publisher     events.EventPublisher
+		// local caches evict what other instances delete while they Run
+		local_caches []*services.LayeredRedisService
+	)
+	if env.local != nil {
+		local_caches = append(local_caches, env.local)
+	}
+
+	if cfg.Dev {
+		logger.Warn("dev mode, nothing is persisted",
+			zap.String("token", devToken),
+			zap.String("username", devUser.Username),
+			zap.String("service_token", devServiceToken),
+		)
+		cfg.Auth.ServiceToken = devServiceToken
+		tracer_closer = nopCloser{}
+		authservice = services.NewFakeAuthService(map[string]services.FakeUser{devToken: devUser})
+		publisher = events.NewMemoryPublisher()
+	} else {
+		var tracer_err error
+		tracer_closer, tracer_err = initTracer(cfg.Jaeger)
+		if tracer_err != nil {
+			return fmt.Errorf("tracer init: %w", tracer_err)
+		}
 
-	router := setupRouter(postdb, authservice, redis_service)
-	err := router.Run(":8080")
-	if err != nil {
-		panic(err)
+		if cfg.Auth.Mode == config.AuthModeJWT {
+			jwt_auth, jwt_err := services.NewJWTAuthService(services.NewUserAuthService(cfg.Auth), cfg.Auth.JWT)
+			if jwt_err != nil {
+				return fmt.Errorf("jwt auth init: %w", jwt_err)
+			}
+			authservice = jwt_auth
+		} else {
+			auth_cache := services.NewAuthCache(env.cache, cfg.Auth.CacheTTL, cfg.Auth.CacheInvalidationChannel)
+			local_caches = append(local_caches, auth_cache)
+			authservice = services.NewCachedAuthService(
+				services.NewUserAuthService(cfg.Auth),
+				auth_cache,
+				cfg.Auth.CacheTTL,
+				cfg.Auth.NegativeCacheTTL,
+			)
+		}
+
+		if cfg.Events.Publisher == config.PublisherMemory {
+			publisher = events.NewMemoryPublisher()
+		} else {
+			publisher = services.NewRedisEventPublisher(cfg.Redis, cfg.Events.Stream, cfg.Events.StreamMaxLen)
+		}
+	}
+
+	if cfg.Mongo.MigrateOnStartup {
+		if err := migrate(env.mongo, cfg.Mongo.MigrationTimeout); err != nil {
+			return fmt.Errorf("migrations: %w", err)
+		}
+		logger.Info("schema is up to date")
+	}
+
+	// Both ports are taken before anything starts, so a busy one fails
+	// startup instead of leaving half a server running.
+	listener, listen_err := net.Listen("tcp", cfg.Addr())
+	if listen_err != nil {
+		return fmt.Errorf("listen: %w", listen_err)
+	}
+	grpc_listener, listen_err := net.Listen("tcp", cfg.GRPCAddr())
+	if listen_err != nil {
+		listener.Close()
+		return fmt.Errorf("grpc listen: %w", listen_err)
+	}
+
+	postdb := env.postdb
+
+	deletions_ctx, stop_deletions := context.WithCancel(context.Background())
+	deletions := newUserDeletionRunner(deletions_ctx, postdb, models.NewUserDeletions(env.mongo), env.posts)
+	if err := deletions.Resume(context.Background()); err != nil {
+		logger.Error("resuming user deletions", zap.Error(err))
+	}
+	deletions.ResumeEvery(models.DeletionLease)
+
+	relay := events.NewRelay(models.NewOutbox(env.mongo), publisher, cfg.Events.RelayInterval, cfg.Events.BatchSize)
+	relay_ctx, stop_relay := context.WithCancel(context.Background())
+	relay_done := make(chan struct{})
+	go func() {
+		relay.Run(relay_ctx)
+		close(relay_done)
+	}()
+
+	local_ctx, stop_local := context.WithCancel(context.Background())
+	var local_done sync.WaitGroup
+	for _, local := range local_caches {
+		local_done.Add(1)
+		go func(local *services.LayeredRedisService) {
+			defer local_done.Done()
+			local.Run(local_ctx)
+		}(local)
+	}
+
+	router := setupRouter(postdb, authservice, env.cache, withUserDeletions(deletions), withPostCache(env.posts), withServiceToken(cfg.Auth.ServiceToken))
+	server := &http.Server{
+		Addr:    cfg.Addr(),
+		Handler: router,
+	}
+	grpc_server := newGRPCServer(postdb, authservice, env.posts, cfg.Auth.ServiceToken)
+
+	// A server that stops on its own shuts the other one down too, and its
+	// error is returned.
+	serve_errs := make(chan error, 2)
+	go func() {
+		logger.Info("listening", zap.String("addr", listener.Addr().String()))
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			serve_errs <- fmt.Errorf("server: %w", err)
+		}
+	}()
+	go func() {
+		logger.Info("grpc listening", zap.String("addr", grpc_listener.Addr().String()))
+		if err := grpc_server.Serve(grpc_listener); err != nil {
+			serve_errs <- fmt.Errorf("grpc server: %w", err)
+		}
+	}()
+
+	var serve_err error
+	select {
+	case <-ctx.Done():
+	case serve_err = <-serve_errs:
+		logger.Error("server failed", zap.Error(serve_err))
+	}
+	logger.Info("shutting down")
+
+	// Stop accepting connections and let in-flight requests finish before
+	// the clients they use are closed.
+	shutdown_ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdown_ctx); err != nil {
+		logger.Error("server shutdown", zap.Error(err))
+	}
+	grpc_stopped := make(chan struct{})
+	go func() {
+		grpc_server.GracefulStop()
+		close(grpc_stopped)
+	}()
+	select {
+	case <-grpc_stopped:
+	case <-shutdown_ctx.Done():
+		grpc_server.Stop()
+	}
+	stop_deletions()
+	deletions.Wait()
+	stop_relay()
+	<-relay_done
+	stop_local()
+	local_done.Wait()
+	if err := publisher.Close(); err != nil {
+		logger.Error("event publisher close", zap.Error(err))
+	}
+	if err := tracer_closer.Close(); err != nil {
+		logger.Error("tracer flush", zap.Error(err))
 	}
+	return serve_err
 
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "http_request_errors_total",
+		Help:      "HTTP requests answered with a 5xx status.",
+	}, []string{"method", "route"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "post_service",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	postCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "post_cache_requests_total",
+		Help:      "Post cache lookups on GET post, by result (hit, stale or miss).",
+	}, []string{"result"})
+)
+
+// metricsMiddleware records request count, latency and errors per route.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := c.Writer.Status()
+		method := c.Request.Method
+
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		if status >= 500 {
+			httpErrors.WithLabelValues(method, route).Inc()
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: helpers/mongodb_helper.go
+
+// Package mock_helpers is a generated GoMock package.
+package mock_helpers
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	helpers "github.com/vinhut/posted/helpers"
+	reflect "reflect"
+)
+
+// MockDatabaseHelper is a mock of DatabaseHelper interface
+type MockDatabaseHelper struct {
+	ctrl     *gomock.Controller
+	recorder *MockDatabaseHelperMockRecorder
+}
+
+// MockDatabaseHelperMockRecorder is the mock recorder for MockDatabaseHelper
+type MockDatabaseHelperMockRecorder struct {
+	mock *MockDatabaseHelper
+}
+
+// NewMockDatabaseHelper creates a new mock instance
+func NewMockDatabaseHelper(ctrl *gomock.Controller) *MockDatabaseHelper {
+	mock := &MockDatabaseHelper{ctrl: ctrl}
+	mock.recorder = &MockDatabaseHelperMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDatabaseHelper) EXPECT() *MockDatabaseHelperMockRecorder {
+	return m.recorder
+}
+
+// Query mocks base method
+func (m *MockDatabaseHelper) Query(arg0 context.Context, arg1, arg2, arg3 string, arg4 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Query", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Query indicates an expected call of Query
+func (mr *MockDatabaseHelperMockRecorder) Query(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockDatabaseHelper)(nil).Query), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Find mocks base method
+func (m *MockDatabaseHelper) Find(arg0 context.Context, arg1 string, arg2 helpers.Query, arg3 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Find", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Find indicates an expected call of Find
+func (mr *MockDatabaseHelperMockRecorder) Find(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockDatabaseHelper)(nil).Find), arg0, arg1, arg2, arg3)
+}
+
+// Stream mocks base method
+func (m *MockDatabaseHelper) Stream(arg0 context.Context, arg1 string, arg2 helpers.Query, arg3 func(func(interface{}) error) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stream", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Stream indicates an expected call of Stream
+func (mr *MockDatabaseHelperMockRecorder) Stream(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stream", reflect.TypeOf((*MockDatabaseHelper)(nil).Stream), arg0, arg1, arg2, arg3)
+}
+
+// Insert mocks base method
+func (m *MockDatabaseHelper) Insert(arg0 context.Context, arg1 string, arg2 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Insert", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Insert indicates an expected call of Insert
+func (mr *MockDatabaseHelperMockRecorder) Insert(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockDatabaseHelper)(nil).Insert), arg0, arg1, arg2)
+}
+
+// InsertMany mocks base method
+func (m *MockDatabaseHelper) InsertMany(arg0 context.Context, arg1 string, arg2 []interface{}) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertMany", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertMany indicates an expected call of InsertMany
+func (mr *MockDatabaseHelperMockRecorder) InsertMany(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMany", reflect.TypeOf((*MockDatabaseHelper)(nil).InsertMany), arg0, arg1, arg2)
+}
+
+// Update mocks base method
+func (m *MockDatabaseHelper) Update(arg0 context.Context, arg1, arg2 string, arg3 map[string]interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update
+func (mr *MockDatabaseHelperMockRecorder) Update(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDatabaseHelper)(nil).Update), arg0, arg1, arg2, arg3)
+}
+
+// UpdateMany mocks base method
+func (m *MockDatabaseHelper) UpdateMany(arg0 context.Context, arg1 string, arg2 helpers.Query, arg3 map[string]interface{}) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMany", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateMany indicates an expected call of UpdateMany
+func (mr *MockDatabaseHelperMockRecorder) UpdateMany(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMany", reflect.TypeOf((*MockDatabaseHelper)(nil).UpdateMany), arg0, arg1, arg2, arg3)
+}
+
+// Delete mocks base method
+func (m *MockDatabaseHelper) Delete(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockDatabaseHelperMockRecorder) Delete(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDatabaseHelper)(nil).Delete), arg0, arg1, arg2)
+}
+
+// DeleteMany mocks base method
+func (m *MockDatabaseHelper) DeleteMany(arg0 context.Context, arg1 string, arg2 helpers.Query) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMany", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMany indicates an expected call of DeleteMany
+func (mr *MockDatabaseHelperMockRecorder) DeleteMany(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMany", reflect.TypeOf((*MockDatabaseHelper)(nil).DeleteMany), arg0, arg1, arg2)
+}
+
+// WithTransaction mocks base method
+func (m *MockDatabaseHelper) WithTransaction(arg0 context.Context, arg1 func(context.Context) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithTransaction", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithTransaction indicates an expected call of WithTransaction
+func (mr *MockDatabaseHelperMockRecorder) WithTransaction(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTransaction", reflect.TypeOf((*MockDatabaseHelper)(nil).WithTransaction), arg0, arg1)
+}
+
+// Migrate mocks base method
+func (m *MockDatabaseHelper) Migrate(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Migrate", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Migrate indicates an expected call of Migrate
+func (mr *MockDatabaseHelperMockRecorder) Migrate(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Migrate", reflect.TypeOf((*MockDatabaseHelper)(nil).Migrate), arg0)
+}
+
+// Reindex mocks base method
+func (m *MockDatabaseHelper) Reindex(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reindex", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reindex indicates an expected call of Reindex
+func (mr *MockDatabaseHelperMockRecorder) Reindex(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reindex", reflect.TypeOf((*MockDatabaseHelper)(nil).Reindex), arg0)
+}
+
+// Ping mocks base method
+func (m *MockDatabaseHelper) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockDatabaseHelperMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockDatabaseHelper)(nil).Ping), arg0)
+}
+
+// Close mocks base method
+func (m *MockDatabaseHelper) Close(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close
+func (mr *MockDatabaseHelperMockRecorder) Close(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockDatabaseHelper)(nil).Close), arg0)
+}
@@ -5,6 +5,7 @@
 package mock_models
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/vinhut/posted/models"
 	reflect "reflect"
@@ -34,90 +35,178 @@ func (m *MockPostDatabase) EXPECT() *MockPostDatabaseMockRecorder {
 }
 
 // Find mocks base method
-func (m *MockPostDatabase) Find(arg0, arg1 string, arg2 interface{}) error {
+func (m *MockPostDatabase) Find(arg0 context.Context, arg1, arg2 string, arg3 interface{}) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Find", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "Find", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Find indicates an expected call of Find
-func (mr *MockPostDatabaseMockRecorder) Find(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) Find(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockPostDatabase)(nil).Find), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockPostDatabase)(nil).Find), arg0, arg1, arg2, arg3)
 }
 
 // FindMulti mocks base method
-func (m *MockPostDatabase) FindMulti(arg0, arg1 string) ([]string, error) {
+func (m *MockPostDatabase) FindMulti(arg0 context.Context, arg1, arg2 string) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindMulti", arg0, arg1)
+	ret := m.ctrl.Call(m, "FindMulti", arg0, arg1, arg2)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindMulti indicates an expected call of FindMulti
-func (mr *MockPostDatabaseMockRecorder) FindMulti(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) FindMulti(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindMulti", reflect.TypeOf((*MockPostDatabase)(nil).FindMulti), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindMulti", reflect.TypeOf((*MockPostDatabase)(nil).FindMulti), arg0, arg1, arg2)
 }
 
 // FindAll mocks base method
-func (m *MockPostDatabase) FindAll(arg0 string) ([]string, error) {
+func (m *MockPostDatabase) FindAll(arg0 context.Context, arg1 string) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindAll", arg0)
+	ret := m.ctrl.Call(m, "FindAll", arg0, arg1)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindAll indicates an expected call of FindAll
-func (mr *MockPostDatabaseMockRecorder) FindAll(arg0 interface{}) *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) FindAll(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAll", reflect.TypeOf((*MockPostDatabase)(nil).FindAll), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAll", reflect.TypeOf((*MockPostDatabase)(nil).FindAll), arg0, arg1)
 }
 
 // Create mocks base method
-func (m *MockPostDatabase) Create(arg0 *models.Post) (bool, error) {
+func (m *MockPostDatabase) Create(arg0 context.Context, arg1 *models.Post) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", arg0)
+	ret := m.ctrl.Call(m, "Create", arg0, arg1)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Create indicates an expected call of Create
-func (mr *MockPostDatabaseMockRecorder) Create(arg0 interface{}) *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockPostDatabase)(nil).Create), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockPostDatabase)(nil).Create), arg0, arg1)
 }
 
 // Update mocks base method
-func (m *MockPostDatabase) Update() (bool, error) {
+func (m *MockPostDatabase) Update(arg0 context.Context, arg1 string, arg2 models.PostUpdate) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update")
+	ret := m.ctrl.Call(m, "Update", arg0, arg1, arg2)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Update indicates an expected call of Update
-func (mr *MockPostDatabaseMockRecorder) Update() *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) Update(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockPostDatabase)(nil).Update))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockPostDatabase)(nil).Update), arg0, arg1, arg2)
 }
 
 // Delete mocks base method
-func (m *MockPostDatabase) Delete(arg0 string) (bool, error) {
+func (m *MockPostDatabase) Delete(arg0 context.Context, arg1 string) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", arg0)
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Delete indicates an expected call of Delete
-func (mr *MockPostDatabaseMockRecorder) Delete(arg0 interface{}) *gomock.Call {
+func (mr *MockPostDatabaseMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockPostDatabase)(nil).Delete), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockPostDatabase)(nil).Delete), arg0, arg1)
+}
+
+// UpdateAuthor mocks base method
+func (m *MockPostDatabase) UpdateAuthor(arg0 context.Context, arg1 string, arg2 models.AuthorUpdate, arg3 int, arg4 func([]string) error) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateAuthor", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateAuthor indicates an expected call of UpdateAuthor
+func (mr *MockPostDatabaseMockRecorder) UpdateAuthor(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAuthor", reflect.TypeOf((*MockPostDatabase)(nil).UpdateAuthor), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Stream mocks base method
+func (m *MockPostDatabase) Stream(arg0 context.Context, arg1 models.PostFilter, arg2 func(models.Post) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stream", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Stream indicates an expected call of Stream
+func (mr *MockPostDatabaseMockRecorder) Stream(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stream", reflect.TypeOf((*MockPostDatabase)(nil).Stream), arg0, arg1, arg2)
+}
+
+// Import mocks base method
+func (m *MockPostDatabase) Import(arg0 context.Context, arg1 []models.Post) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Import", arg0, arg1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Import indicates an expected call of Import
+func (mr *MockPostDatabaseMockRecorder) Import(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Import", reflect.TypeOf((*MockPostDatabase)(nil).Import), arg0, arg1)
+}
+
+// DeleteByUser mocks base method
+func (m *MockPostDatabase) DeleteByUser(arg0 context.Context, arg1 string, arg2 int, arg3 func([]string) error) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByUser", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteByUser indicates an expected call of DeleteByUser
+func (mr *MockPostDatabaseMockRecorder) DeleteByUser(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByUser", reflect.TypeOf((*MockPostDatabase)(nil).DeleteByUser), arg0, arg1, arg2, arg3)
+}
+
+// AnonymizeByUser mocks base method
+func (m *MockPostDatabase) AnonymizeByUser(arg0 context.Context, arg1 string, arg2 int, arg3 func([]string) error) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AnonymizeByUser", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AnonymizeByUser indicates an expected call of AnonymizeByUser
+func (mr *MockPostDatabaseMockRecorder) AnonymizeByUser(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnonymizeByUser", reflect.TypeOf((*MockPostDatabase)(nil).AnonymizeByUser), arg0, arg1, arg2, arg3)
+}
+
+// Ping mocks base method
+func (m *MockPostDatabase) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockPostDatabaseMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockPostDatabase)(nil).Ping), arg0)
 }
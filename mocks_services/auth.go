@@ -5,6 +5,7 @@
 package mock_services
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
 )
@@ -33,76 +34,90 @@ func (m *MockAuthService) EXPECT() *MockAuthServiceMockRecorder {
 }
 
 // Login mocks base method
-func (m *MockAuthService) Login(service, email, password string) (string, error) {
+func (m *MockAuthService) Login(ctx context.Context, service, email, password string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Login", service, email, password)
+	ret := m.ctrl.Call(m, "Login", ctx, service, email, password)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Login indicates an expected call of Login
-func (mr *MockAuthServiceMockRecorder) Login(service, email, password interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Login(ctx, service, email, password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockAuthService)(nil).Login), service, email, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockAuthService)(nil).Login), ctx, service, email, password)
 }
 
 // Check mocks base method
-func (m *MockAuthService) Check(service, token string) (string, error) {
+func (m *MockAuthService) Check(ctx context.Context, service, token string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Check", service, token)
+	ret := m.ctrl.Call(m, "Check", ctx, service, token)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Check indicates an expected call of Check
-func (mr *MockAuthServiceMockRecorder) Check(service, token interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Check(ctx, service, token interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*MockAuthService)(nil).Check), service, token)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*MockAuthService)(nil).Check), ctx, service, token)
 }
 
 // Update mocks base method
-func (m *MockAuthService) Update() (bool, error) {
+func (m *MockAuthService) Update(ctx context.Context) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update")
+	ret := m.ctrl.Call(m, "Update", ctx)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Update indicates an expected call of Update
-func (mr *MockAuthServiceMockRecorder) Update() *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Update(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockAuthService)(nil).Update))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockAuthService)(nil).Update), ctx)
 }
 
 // Create mocks base method
-func (m *MockAuthService) Create(service, email, password string) (bool, error) {
+func (m *MockAuthService) Create(ctx context.Context, service, email, password string) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", service, email, password)
+	ret := m.ctrl.Call(m, "Create", ctx, service, email, password)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Create indicates an expected call of Create
-func (mr *MockAuthServiceMockRecorder) Create(service, email, password interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Create(ctx, service, email, password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAuthService)(nil).Create), service, email, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAuthService)(nil).Create), ctx, service, email, password)
 }
 
 // Delete mocks base method
-func (m *MockAuthService) Delete(arg0 string) (bool, error) {
+func (m *MockAuthService) Delete(arg0 context.Context, arg1 string) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", arg0)
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Delete indicates an expected call of Delete
-func (mr *MockAuthServiceMockRecorder) Delete(arg0 interface{}) *gomock.Call {
+func (mr *MockAuthServiceMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAuthService)(nil).Delete), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAuthService)(nil).Delete), arg0, arg1)
+}
+
+// Ping mocks base method
+func (m *MockAuthService) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockAuthServiceMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockAuthService)(nil).Ping), arg0)
 }
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vinhut/posted/helpers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const deletionTableName = "user_deletions"
+
+// What a UserDeletion does with the posts of the account.
+const (
+	DeletionModeDelete    = "delete"
+	DeletionModeAnonymize = "anonymize"
+)
+
+const (
+	DeletionPending = "pending"
+	DeletionRunning = "running"
+	DeletionDone    = "done"
+	DeletionFailed  = "failed"
+)
+
+// DeletionLease is how long a claimed job stays with its instance without
+// saving progress. After that another instance may claim it.
+const DeletionLease = time.Minute
+
+// ErrLeaseLost means another instance claimed the job meanwhile.
+var ErrLeaseLost = errors.New("user deletion claimed by another instance")
+
+// UserDeletion is the stored state of the job that removes a deleted
+// account's posts. It survives restarts, so an interrupted job picks up
+// where it stopped.
+type UserDeletion struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Uid       string             `bson:"uid" json:"uid"`
+	Mode      string             `bson:"mode" json:"mode"`
+	Status    string             `bson:"status" json:"status"`
+	Processed int                `bson:"processed" json:"processed"`
+	Error     string             `bson:"error,omitempty" json:"error,omitempty"`
+	Created   time.Time          `bson:"created" json:"created"`
+	Updated   time.Time          `bson:"updated" json:"updated"`
+	// Active is set until the job finishes. A unique index on the uid of
+	// active jobs keeps each user to one unfinished job.
+	Active bool `bson:"active" json:"-"`
+	// Owner is the instance running the job. It holds the job until
+	// LeaseUntil, every save extends that.
+	Owner      string    `bson:"owner" json:"-"`
+	LeaseUntil time.Time `bson:"lease_until" json:"-"`
+}
+
+// Finished reports whether the job has stopped for good.
+func (job *UserDeletion) Finished() bool {
+	return job.Status == DeletionDone || job.Status == DeletionFailed
+}
+
+type UserDeletions interface {
+	Create(context.Context, string, string) (*UserDeletion, error)
+	Latest(context.Context, string) (*UserDeletion, error)
+	Unfinished(context.Context) ([]UserDeletion, error)
+	Claim(context.Context, *UserDeletion, string) (bool, error)
+	Save(context.Context, *UserDeletion) error
+	Release(context.Context, *UserDeletion) error
+}
+
+type userDeletions struct {
+	jobs *helpers.Repository[UserDeletion]
+}
+
+func NewUserDeletions(db helpers.DatabaseHelper) UserDeletions {
+	return &userDeletions{
+		jobs: helpers.NewRepository[UserDeletion](db, deletionTableName),
+	}
+}
+
+// Create stores a pending job for uid, or fails with ErrConflict when uid
+// has an unfinished job already.
+func (deletions *userDeletions) Create(ctx context.Context, uid, mode string) (*UserDeletion, error) {
+	if latest, err := deletions.Latest(ctx, uid); err == nil && !latest.Finished() {
+		return nil, ErrConflict
+	}
+	now := time.Now().UTC()
+	job := &UserDeletion{
+		ID:      primitive.NewObjectIDFromTimestamp(now),
+		Uid:     uid,
+		Mode:    mode,
+		Status:  DeletionPending,
+		Created: now,
+		Updated: now,
+		Active:  true,
+	}
+	// the unique index catches a job created elsewhere since the check
+	if err := deletions.jobs.Insert(ctx, *job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Latest returns the newest job for uid, or ErrNotFound.
+func (deletions *userDeletions) Latest(ctx context.Context, uid string) (*UserDeletion, error) {
+	query := helpers.NewQuery().Where("uid", helpers.Eq, uid).Sort("_id", helpers.Descending).Limit(1)
+	jobs, err := deletions.jobs.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &jobs[0], nil
+}
+
+func (deletions *userDeletions) Unfinished(ctx context.Context) ([]UserDeletion, error) {
+	query := helpers.NewQuery().Where("status", helpers.In, []string{DeletionPending, DeletionRunning}).Sort("_id", helpers.Ascending)
+	return deletions.jobs.Find(ctx, query)
+}
+
+// Claim makes owner the instance running job, unless another one holds
+// it. It reports whether the job was claimed, and then updates job.
+func (deletions *userDeletions) Claim(ctx context.Context, job *UserDeletion, owner string) (bool, error) {
+	now := time.Now().UTC()
+	claimable := helpers.NewQuery().
+		Where("_id", helpers.Eq, job.ID).
+		Where("active", helpers.Eq, true).
+		Where("lease_until", helpers.Lt, now)
+	claimed, err := deletions.jobs.UpdateMany(ctx, claimable, map[string]interface{}{
+		"status":      DeletionRunning,
+		"owner":       owner,
+		"lease_until": now.Add(DeletionLease),
+		"updated":     now,
+	})
+	if err != nil || claimed == 0 {
+		return false, err
+	}
+	job.Status = DeletionRunning
+	job.Owner = owner
+	job.LeaseUntil = now.Add(DeletionLease)
+	job.Updated = now
+	return true, nil
+}
+
+// Save stores the status, progress and error of job and extends its lease.
+// It fails with ErrLeaseLost when job.Owner no longer holds the job.
+func (deletions *userDeletions) Save(ctx context.Context, job *UserDeletion) error {
+	job.Updated = time.Now().UTC()
+	job.Active = !job.Finished()
+	if job.Active {
+		job.LeaseUntil = job.Updated.Add(DeletionLease)
+	}
+	return deletions.saveOwned(ctx, job)
+}
+
+// Release hands job back before its lease runs out, so the next instance
+// to look can claim it right away.
+func (deletions *userDeletions) Release(ctx context.Context, job *UserDeletion) error {
+	job.Updated = time.Now().UTC()
+	job.LeaseUntil = time.Time{}
+	return deletions.saveOwned(ctx, job)
+}
+
+func (deletions *userDeletions) saveOwned(ctx context.Context, job *UserDeletion) error {
+	owned := helpers.NewQuery().Where("_id", helpers.Eq, job.ID).Where("owner", helpers.Eq, job.Owner)
+	saved, err := deletions.jobs.UpdateMany(ctx, owned, map[string]interface{}{
+		"status":      job.Status,
+		"processed":   job.Processed,
+		"error":       job.Error,
+		"updated":     job.Updated,
+		"active":      job.Active,
+		"lease_until": job.LeaseUntil,
+	})
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/vinhut/posted/helpers"
+)
+
+var (
+	ErrNotFound  = helpers.ErrNotFound
+	ErrInvalidID = helpers.ErrInvalidID
+	ErrConflict  = helpers.ErrConflict
+	// ErrForbidden means the post exists but belongs to another user.
+	ErrForbidden = errors.New("forbidden")
+)
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vinhut/posted/events"
+	"github.com/vinhut/posted/helpers"
+)
+
+const outboxTableName = "outbox"
+
+type outbox struct {
+	events *helpers.Repository[events.Event]
+}
+
+// NewOutbox reads the events postDatabase writes next to each post change.
+func NewOutbox(db helpers.DatabaseHelper) events.Outbox {
+	return &outbox{
+		events: helpers.NewRepository[events.Event](db, outboxTableName),
+	}
+}
+
+func (box *outbox) Pending(ctx context.Context, limit int) ([]events.Event, error) {
+	// Object ids start with their creation time.
+	query := helpers.NewQuery().Sort("_id", helpers.Ascending).Limit(int64(limit))
+	return box.events.Find(ctx, query)
+}
+
+func (box *outbox) Ack(ctx context.Context, event events.Event) error {
+	err := box.events.Delete(ctx, event.ID.Hex())
+	if errors.Is(err, ErrNotFound) {
+		// acked before, the event was published twice
+		return nil
+	}
+	return err
+}
@@ -1,29 +1,39 @@
 package models
 
 import (
-	"fmt"
+	"context"
+	"github.com/vinhut/posted/events"
 	"github.com/vinhut/posted/helpers"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
 	"time"
 )
 
 const tableName = "posts"
 
 type PostDatabase interface {
-	Find(string, string, interface{}) error
-	FindMulti(string, string) ([]string, error)
-	FindAll(string) ([]string, error)
-	Create(*Post) (bool, error)
-	Update() (bool, error)
-	Delete(string) (bool, error)
+	Find(context.Context, string, string, interface{}) error
+	FindMulti(context.Context, string, string) ([]string, error)
+	FindAll(context.Context, string) ([]string, error)
+	Create(context.Context, *Post) (bool, error)
+	Update(context.Context, string, PostUpdate) (bool, error)
+	Delete(context.Context, string) (bool, error)
+	UpdateAuthor(context.Context, string, AuthorUpdate, int, func([]string) error) (int, error)
+	Stream(context.Context, PostFilter, func(Post) error) error
+	Import(context.Context, []Post) (int, error)
+	DeleteByUser(context.Context, string, int, func([]string) error) (int, error)
+	AnonymizeByUser(context.Context, string, int, func([]string) error) (int, error)
+	Ping(context.Context) error
 }
 
 type postDatabase struct {
-	db helpers.DatabaseHelper
+	db     helpers.DatabaseHelper
+	posts  *helpers.Repository[Post]
+	outbox *helpers.Repository[events.Event]
 }
 
 type Post struct {
-	Postid       primitive.ObjectID `bson:"_id, omitempty"`
+	Postid       primitive.ObjectID `bson:"_id,omitempty"`
 	Uid          string
 	Username     string
 	Screenname   string
@@ -39,19 +49,100 @@ type Post struct {
 	Tag          []string
 }
 
+// PostUpdate holds the user editable fields of a post. Nil fields are left
+// unchanged.
+type PostUpdate struct {
+	Imageurl *string
+	Caption  *string
+	Tag      *[]string
+}
+
+func (update PostUpdate) fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	if update.Imageurl != nil {
+		fields["imageurl"] = *update.Imageurl
+	}
+	if update.Caption != nil {
+		fields["caption"] = *update.Caption
+	}
+	if update.Tag != nil {
+		fields["tag"] = *update.Tag
+	}
+	return fields
+}
+
+// AuthorUpdate holds the profile fields of a user that each of their posts
+// keeps a copy of. Nil fields are left unchanged.
+type AuthorUpdate struct {
+	Username   *string
+	Screenname *string
+	Avatarurl  *string
+	Verified   *bool
+}
+
+func (update AuthorUpdate) fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	if update.Username != nil {
+		fields["username"] = *update.Username
+	}
+	if update.Screenname != nil {
+		fields["screenname"] = *update.Screenname
+	}
+	if update.Avatarurl != nil {
+		fields["avatarurl"] = *update.Avatarurl
+	}
+	if update.Verified != nil {
+		fields["verified"] = *update.Verified
+	}
+	return fields
+}
+
+// PostFilter selects posts by author and creation time. Zero fields match
+// every post.
+type PostFilter struct {
+	Uid  string
+	From time.Time
+	// To is exclusive
+	To time.Time
+}
+
+func (filter PostFilter) query() helpers.Query {
+	query := helpers.NewQuery()
+	if filter.Uid != "" {
+		query = query.Where("uid", helpers.Eq, filter.Uid)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created", helpers.Gte, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created", helpers.Lt, filter.To)
+	}
+	return query
+}
+
 func PostUser() Post {
 	post := Post{}
 	return post
 }
 
+// CheckOwner returns ErrForbidden unless the post was written by uid.
+func (post *Post) CheckOwner(uid string) error {
+	if post.Uid != uid {
+		return ErrForbidden
+	}
+	return nil
+}
+
 func NewPostDatabase(db helpers.DatabaseHelper) PostDatabase {
 	return &postDatabase{
-		db: db,
+		db:     db,
+		posts:  helpers.NewRepository[Post](db, tableName),
+		outbox: helpers.NewRepository[events.Event](db, outboxTableName),
 	}
 }
 
-func (postdb *postDatabase) Find(column, value string, result_user interface{}) error {
-	err := postdb.db.Query(tableName, column, value, result_user)
+func (postdb *postDatabase) Find(ctx context.Context, column, value string, result_user interface{}) error {
+	err := postdb.db.Query(ctx, tableName, column, value, result_user)
 	if err != nil {
 		return err
 	}
@@ -59,74 +150,261 @@ func (postdb *postDatabase) Find(column, value string, result_user interface{})
 	return nil
 }
 
-func (postdb *postDatabase) FindMulti(column, value string) ([]string, error) {
-
-	var result_str []string
-	data, result_err := postdb.db.FindMulti(tableName, column, value, Post{})
+// FindMulti returns the ids of the posts whose column holds value.
+func (postdb *postDatabase) FindMulti(ctx context.Context, column, value string) ([]string, error) {
+	query := helpers.NewQuery().Where(column, helpers.Eq, value)
+	return postdb.findIDs(ctx, query)
+}
 
-	results := make([]Post, len(data))
+// FindAll returns the ids of at most post_range posts, or all of them for 0,
+// in the collection's natural order.
+func (postdb *postDatabase) FindAll(ctx context.Context, post_range string) ([]string, error) {
+	limit, _ := strconv.ParseInt(post_range, 10, 64)
+	query := helpers.NewQuery().Limit(limit)
+	return postdb.findIDs(ctx, query)
+}
 
-	if result_err != nil {
-		return nil, result_err
-	}
+func (postdb *postDatabase) findIDs(ctx context.Context, query helpers.Query) ([]string, error) {
 
-	for i, d := range data {
-		if d == nil {
-			fmt.Println("d is nil")
-		}
-		fmt.Println("d = ", d)
-		results[i] = d.(Post)
+	results, err := postdb.posts.Find(ctx, query.Select("_id"))
+	if err != nil {
+		return nil, err
 	}
 
+	var result_str []string
 	for _, post := range results {
 		result_str = append(result_str, post.Postid.Hex())
 	}
-
 	return result_str, nil
 }
 
-func (postdb *postDatabase) FindAll(post_range string) ([]string, error) {
+// Create, Update and Delete write the post change and its event to the
+// outbox in one transaction, so an event is emitted exactly when the
+// change is stored.
+func (postdb *postDatabase) Create(ctx context.Context, post *Post) (bool, error) {
+	if post.Tag == nil {
+		post.Tag = []string{}
+	}
+	event := events.New(events.PostCreated, post.Postid.Hex(), map[string]interface{}{
+		"uid":        post.Uid,
+		"username":   post.Username,
+		"screenname": post.Screenname,
+		"avatarurl":  post.Avatarurl,
+		"imageurl":   post.Imageurl,
+		"caption":    post.Caption,
+		"tag":        post.Tag,
+		"created":    post.Created,
+	})
+	err := postdb.db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+		if err := postdb.posts.Insert(tx_ctx, *post); err != nil {
+			return err
+		}
+		return postdb.outbox.Insert(tx_ctx, event)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	var result_str []string
-	data, result_err := postdb.db.FindAll(tableName, post_range, Post{})
+func (postdb *postDatabase) Update(ctx context.Context, postid string, update PostUpdate) (bool, error) {
+	fields := update.fields()
+	if len(fields) == 0 {
+		return false, nil
+	}
+	event := events.New(events.PostUpdated, postid, fields)
+	err := postdb.db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+		if err := postdb.posts.Update(tx_ctx, postid, fields); err != nil {
+			return err
+		}
+		return postdb.outbox.Insert(tx_ctx, event)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	results := make([]Post, len(data))
+func (postdb *postDatabase) Delete(ctx context.Context, postid string) (bool, error) {
 
-	if result_err != nil {
-		return nil, result_err
+	event := events.New(events.PostDeleted, postid, nil)
+	err := postdb.db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+		if err := postdb.posts.Delete(tx_ctx, postid); err != nil {
+			return err
+		}
+		return postdb.outbox.Insert(tx_ctx, event)
+	})
+	if err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	for i, d := range data {
-		if d == nil {
-			fmt.Println("d is nil")
-		}
-		results[i] = d.(Post)
+// UpdateAuthor copies a user's new profile into all of their posts. It
+// works through them batch_size at a time, in id order, so no single write
+// holds the collection for long, and calls done with the ids of each
+// updated batch. Each batch is written with its PostUpdated events in one
+// transaction. An error from done stops the update. It returns the number
+// of posts updated; running it again with the same update is harmless.
+func (postdb *postDatabase) UpdateAuthor(ctx context.Context, uid string, update AuthorUpdate, batch_size int, done func([]string) error) (int, error) {
+
+	fields := update.fields()
+	if len(fields) == 0 {
+		return 0, nil
 	}
 
-	for _, post := range results {
-		result_str = append(result_str, post.Postid.Hex())
+	by_uid := helpers.NewQuery().Where("uid", helpers.Eq, uid)
+	first_page := by_uid.Sort("_id", helpers.Ascending).Limit(int64(batch_size)).Select("_id")
+	page := first_page
+	updated := 0
+	for {
+		batch, err := postdb.posts.Find(ctx, page)
+		if err != nil {
+			return updated, err
+		}
+		if len(batch) == 0 {
+			return updated, nil
+		}
+
+		ids := make([]primitive.ObjectID, len(batch))
+		hex_ids := make([]string, len(batch))
+		for i, post := range batch {
+			ids[i] = post.Postid
+			hex_ids[i] = post.Postid.Hex()
+		}
+		err = postdb.db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+			// the uid condition skips posts moved to another user meanwhile
+			if _, err := postdb.posts.UpdateMany(tx_ctx, by_uid.Where("_id", helpers.In, ids), fields); err != nil {
+				return err
+			}
+			for _, postid := range hex_ids {
+				if err := postdb.outbox.Insert(tx_ctx, events.New(events.PostUpdated, postid, fields)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+		updated += len(batch)
+		if err := done(hex_ids); err != nil {
+			return updated, err
+		}
+
+		if len(batch) < batch_size {
+			return updated, nil
+		}
+		page = first_page.Where("_id", helpers.Gt, ids[len(ids)-1])
 	}
+}
 
-	return result_str, nil
+// Stream calls fn with each post matching filter, oldest first.
+func (postdb *postDatabase) Stream(ctx context.Context, filter PostFilter, fn func(Post) error) error {
+	return postdb.posts.Stream(ctx, filter.query().Sort("_id", helpers.Ascending), fn)
 }
 
-func (postdb *postDatabase) Create(post *Post) (bool, error) {
-	err := postdb.db.Insert(tableName, post)
-	if err != nil {
-		return false, err
+// Import stores posts as they are, ids included, and returns how many it
+// stored. Posts whose id is taken already are skipped, so importing the
+// same file twice is harmless. Unlike Create it emits no events, imported
+// posts aren't new to the system.
+func (postdb *postDatabase) Import(ctx context.Context, posts []Post) (int, error) {
+	for i := range posts {
+		if posts[i].Postid.IsZero() {
+			posts[i].Postid = primitive.NewObjectIDFromTimestamp(posts[i].Created)
+		}
+		if posts[i].Tag == nil {
+			posts[i].Tag = []string{}
+		}
 	}
-	return true, nil
+	inserted, err := postdb.posts.InsertMany(ctx, posts)
+	return int(inserted), err
 }
 
-func (postdb *postDatabase) Update() (bool, error) {
-	return false, nil
+// anonymousAuthor replaces the author of the posts of a deleted account
+// that are kept.
+var anonymousAuthor = map[string]interface{}{
+	"uid":        "",
+	"username":   "",
+	"screenname": "Deleted user",
+	"avatarurl":  "",
+	"verified":   false,
+}
+
+// DeleteByUser deletes all posts of uid, batch_size at a time, each batch
+// in one transaction with its PostDeleted events. It calls done with the
+// ids of each deleted batch and returns how many posts it deleted. Deleted
+// posts no longer match, so an interrupted run is resumed by running it
+// again.
+func (postdb *postDatabase) DeleteByUser(ctx context.Context, uid string, batch_size int, done func([]string) error) (int, error) {
+	return postdb.removeByUser(ctx, uid, batch_size, done, func(tx_ctx context.Context, batch helpers.Query, postids []string) error {
+		if _, err := postdb.posts.DeleteMany(tx_ctx, batch); err != nil {
+			return err
+		}
+		for _, postid := range postids {
+			if err := postdb.outbox.Insert(tx_ctx, events.New(events.PostDeleted, postid, nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (postdb *postDatabase) Delete(postid string) (bool, error) {
+// AnonymizeByUser is DeleteByUser for accounts whose posts stay up: it
+// replaces their author with anonymousAuthor instead and emits PostUpdated.
+func (postdb *postDatabase) AnonymizeByUser(ctx context.Context, uid string, batch_size int, done func([]string) error) (int, error) {
+	return postdb.removeByUser(ctx, uid, batch_size, done, func(tx_ctx context.Context, batch helpers.Query, postids []string) error {
+		if _, err := postdb.posts.UpdateMany(tx_ctx, batch, anonymousAuthor); err != nil {
+			return err
+		}
+		for _, postid := range postids {
+			if err := postdb.outbox.Insert(tx_ctx, events.New(events.PostUpdated, postid, anonymousAuthor)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	err := postdb.db.Delete(tableName, postid)
-	if err != nil {
-		return false, err
+// removeByUser runs remove on the posts of uid, batch by batch, until none
+// are left. remove must take its batch out of the uid's posts.
+func (postdb *postDatabase) removeByUser(ctx context.Context, uid string, batch_size int, done func([]string) error,
+	remove func(context.Context, helpers.Query, []string) error) (int, error) {
+
+	by_uid := helpers.NewQuery().Where("uid", helpers.Eq, uid)
+	page := by_uid.Sort("_id", helpers.Ascending).Limit(int64(batch_size)).Select("_id")
+	removed := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		batch, err := postdb.posts.Find(ctx, page)
+		if err != nil {
+			return removed, err
+		}
+		if len(batch) == 0 {
+			return removed, nil
+		}
+
+		ids := make([]primitive.ObjectID, len(batch))
+		postids := make([]string, len(batch))
+		for i, post := range batch {
+			ids[i] = post.Postid
+			postids[i] = post.Postid.Hex()
+		}
+		err = postdb.db.WithTransaction(ctx, func(tx_ctx context.Context) error {
+			return remove(tx_ctx, by_uid.Where("_id", helpers.In, ids), postids)
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += len(batch)
+		if err := done(postids); err != nil {
+			return removed, err
+		}
 	}
-	return true, nil
+}
+
+func (postdb *postDatabase) Ping(ctx context.Context) error {
+	return postdb.db.Ping(ctx)
 }
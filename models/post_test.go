@@ -0,0 +1,159 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/events"
+	"github.com/vinhut/posted/helpers"
+	mocks_helpers "github.com/vinhut/posted/mocks_helpers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPostDatabaseOutbox(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock_db := mocks_helpers.NewMockDatabaseHelper(ctrl)
+
+	in_transaction := func(ctx context.Context, fn func(context.Context) error) error {
+		return fn(ctx)
+	}
+	var written []events.Event
+	record_event := func(ctx context.Context, collection string, data interface{}) error {
+		written = append(written, data.(events.Event))
+		return nil
+	}
+
+	post := &Post{Postid: primitive.NewObjectID(), Uid: "1", Caption: "test caption", Tag: []string{"go"}}
+	caption := "edited"
+
+	mock_db.EXPECT().WithTransaction(gomock.Any(), gomock.Any()).DoAndReturn(in_transaction).Times(4)
+	gomock.InOrder(
+		mock_db.EXPECT().Insert(gomock.Any(), "posts", *post).Return(nil),
+		mock_db.EXPECT().Insert(gomock.Any(), "outbox", gomock.Any()).DoAndReturn(record_event),
+	)
+	gomock.InOrder(
+		mock_db.EXPECT().Update(gomock.Any(), "posts", post.Postid.Hex(), map[string]interface{}{"caption": caption}).Return(nil),
+		mock_db.EXPECT().Insert(gomock.Any(), "outbox", gomock.Any()).DoAndReturn(record_event),
+	)
+	gomock.InOrder(
+		mock_db.EXPECT().Delete(gomock.Any(), "posts", post.Postid.Hex()).Return(nil),
+		mock_db.EXPECT().Insert(gomock.Any(), "outbox", gomock.Any()).DoAndReturn(record_event),
+	)
+	mock_db.EXPECT().Delete(gomock.Any(), "posts", "5f8f8c44b54764421b7156c3").Return(ErrNotFound)
+
+	postdb := NewPostDatabase(mock_db)
+	_, err := postdb.Create(context.Background(), post)
+	assert.NoError(t, err)
+	_, err = postdb.Update(context.Background(), post.Postid.Hex(), PostUpdate{Caption: &caption})
+	assert.NoError(t, err)
+	_, err = postdb.Delete(context.Background(), post.Postid.Hex())
+	assert.NoError(t, err)
+
+	// a failed write emits nothing
+	_, err = postdb.Delete(context.Background(), "5f8f8c44b54764421b7156c3")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	if assert.Len(t, written, 3) {
+		assert.Equal(t, events.PostCreated, written[0].Type)
+		assert.Equal(t, "test caption", written[0].Data["caption"])
+		assert.Equal(t, events.PostUpdated, written[1].Type)
+		assert.Equal(t, map[string]interface{}{"caption": caption}, written[1].Data)
+		assert.Equal(t, events.PostDeleted, written[2].Type)
+		for _, event := range written {
+			assert.Equal(t, post.Postid.Hex(), event.PostID)
+			assert.False(t, event.ID.IsZero())
+		}
+		assert.NotEqual(t, written[0].ID, written[1].ID)
+	}
+
+}
+func TestUpdateAuthor(t *testing.T) {
+
+	ctx := context.Background()
+	db := helpers.NewMemoryDatabase()
+	postdb := NewPostDatabase(db)
+
+	for i := 0; i < 5; i++ {
+		_, err := postdb.Create(ctx, &Post{Postid: primitive.NewObjectID(), Uid: "1", Username: "alice"})
+		assert.NoError(t, err)
+	}
+	other := &Post{Postid: primitive.NewObjectID(), Uid: "2", Username: "bob"}
+	postdb.Create(ctx, other)
+
+	username := "alice2"
+	verified := true
+	update := AuthorUpdate{Username: &username, Verified: &verified}
+	var batches [][]string
+	updated, err := postdb.UpdateAuthor(ctx, "1", update, 2, func(postids []string) error {
+		batches = append(batches, postids)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, updated)
+	if assert.Len(t, batches, 3) {
+		assert.Len(t, batches[0], 2)
+		assert.Len(t, batches[2], 1)
+		assert.NotEqual(t, batches[0][0], batches[1][0])
+	}
+
+	user_posts, _ := postdb.FindMulti(ctx, "username", "alice2")
+	assert.Len(t, user_posts, 5)
+	for _, postid := range user_posts {
+		post := Post{}
+		assert.NoError(t, postdb.Find(ctx, "_id", postid, &post))
+		assert.True(t, post.Verified)
+	}
+	post := Post{}
+	postdb.Find(ctx, "_id", other.Postid.Hex(), &post)
+	assert.Equal(t, "bob", post.Username)
+
+	pending, _ := NewOutbox(db).Pending(ctx, 100)
+	author_events := 0
+	for _, event := range pending {
+		if event.Type == events.PostUpdated {
+			author_events++
+			assert.Equal(t, "alice2", event.Data["username"])
+		}
+	}
+	assert.Equal(t, 5, author_events)
+
+	// a failing batch stops the sync
+	stop := errors.New("cache down")
+	updated, err = postdb.UpdateAuthor(ctx, "1", update, 2, func([]string) error { return stop })
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, updated)
+
+	updated, err = postdb.UpdateAuthor(ctx, "1", AuthorUpdate{}, 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, updated)
+
+}
+
+func TestFindAll(t *testing.T) {
+
+	ctx := context.Background()
+	postdb := NewPostDatabase(helpers.NewMemoryDatabase())
+
+	// posts come back as stored, not by creation time
+	now := time.Now()
+	var postids []string
+	for _, created := range []time.Time{now, now.Add(-time.Hour), now.Add(time.Hour)} {
+		post := &Post{Postid: primitive.NewObjectID(), Uid: "1", Created: created}
+		_, err := postdb.Create(ctx, post)
+		assert.NoError(t, err)
+		postids = append(postids, post.Postid.Hex())
+	}
+
+	all, err := postdb.FindAll(ctx, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, postids, all)
+	limited, _ := postdb.FindAll(ctx, "2")
+	assert.Equal(t, postids[:2], limited)
+
+}
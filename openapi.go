@@ -0,0 +1,555 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vinhut/posted/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const openAPIVersion = "1.0.0"
+
+// The OpenAPI 3 document is assembled from the routes gin actually has,
+// each described by an entry in routeDocs. Request and response schemas are
+// derived from the Go types the handlers bind and marshal, so they follow
+// the code. TestOpenAPIRoutes fails when a route has no entry.
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	MinLength            int                       `json:"minLength,omitempty"`
+	MaxLength            int                       `json:"maxLength,omitempty"`
+	MaxItems             int                       `json:"maxItems,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Default              interface{}               `json:"default,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+func schemaRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// schemaOf describes how encoding/json renders a value of type t. With
+// required set every property is listed as required, which holds for the
+// structs the service marshals itself.
+func schemaOf(t reflect.Type, required bool) *openAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case objectIDType:
+		return &openAPISchema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice:
+		return &openAPISchema{Type: "array", Nullable: true, Items: schemaOf(t.Elem(), required)}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), required)}
+	case reflect.Struct:
+		schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		addStructFields(schema, t, required)
+		return schema
+	}
+	return &openAPISchema{}
+}
+
+func addStructFields(schema *openAPISchema, t reflect.Type, required bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(schema, field.Type, required)
+			continue
+		}
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = schemaOf(field.Type, required)
+		if required && !strings.Contains(tag, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// postBodySchema adds the limits enforced by validateImageURL,
+// validateCaption and validateTags to a request body schema.
+func postBodySchema(t reflect.Type) *openAPISchema {
+	schema := schemaOf(t, false)
+	schema.Properties["img_url"].Format = "uri"
+	schema.Properties["img_url"].MaxLength = maxURLLength
+	schema.Properties["post_caption"].MaxLength = maxCaptionLength
+	tags := schema.Properties["tags"]
+	tags.MaxItems = maxTags
+	tags.Items.Pattern = tagPattern.String()
+	tags.Items.MinLength = 1
+	tags.Items.MaxLength = maxTagLength
+	return schema
+}
+
+// formBodySchema is the form encoded variant of a request body, where tags
+// are one comma separated string.
+func formBodySchema(json_schema *openAPISchema) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for name, property := range json_schema.Properties {
+		schema.Properties[name] = property
+	}
+	if _, has_tags := schema.Properties["tags"]; has_tags {
+		schema.Properties["tags"] = &openAPISchema{Type: "string"}
+	}
+	schema.Required = json_schema.Required
+	return schema
+}
+
+func postBody(schema string) *openAPIRequestBody {
+	return &openAPIRequestBody{
+		Required: true,
+		Content: map[string]openAPIMedia{
+			gin.MIMEJSON:              {Schema: schemaRef(schema)},
+			gin.MIMEPOSTForm:          {Schema: schemaRef(schema + "Form")},
+			gin.MIMEMultipartPOSTForm: {Schema: schemaRef(schema + "Form")},
+		},
+	}
+}
+
+func textResponse(description string) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMedia{gin.MIMEPlain: {Schema: &openAPISchema{Type: "string"}}},
+	}
+}
+
+func jsonResponse(description, schema string) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMedia{gin.MIMEJSON: {Schema: schemaRef(schema)}},
+	}
+}
+
+// withErrors adds the error envelope for each listed status.
+func withErrors(responses map[string]openAPIResponse, statuses ...int) map[string]openAPIResponse {
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = jsonResponse(errorDescription(status), "Error")
+	}
+	return responses
+}
+
+func errorDescription(status int) string {
+	switch status {
+	case 400:
+		return "Malformed request or post id"
+	case 401:
+		return "Missing or invalid token"
+	case 403:
+		return "Post belongs to another user"
+	case 404:
+		return "Post not found"
+	case 409:
+		return "Post already exists"
+	case 422:
+		return "Request body failed validation"
+	case 501:
+		return "Not supported by this deployment"
+	case 503:
+		return "Auth service unavailable"
+	}
+	return "Internal error"
+}
+
+var (
+	cookieAuth  = []map[string][]string{{"cookieAuth": {}}}
+	serviceAuth = []map[string][]string{{"serviceAuth": {}}}
+	postIDParam = openAPIParameter{
+		Name: "postid", In: "query", Required: true,
+		Schema: &openAPISchema{Type: "string", Pattern: "^[0-9a-f]{24}$"},
+	}
+)
+
+// routeDocs documents every route, keyed by method and gin path.
+var routeDocs = map[string]*openAPIOperation{
+	"GET /ping": {
+		Summary:   "Liveness check kept for older deployments",
+		Responses: map[string]openAPIResponse{"200": textResponse("OK")},
+	},
+	"GET /metrics": {
+		Summary:   "Prometheus metrics",
+		Responses: map[string]openAPIResponse{"200": textResponse("Metrics in the Prometheus text format")},
+	},
+	"GET /healthz": {
+		Summary:   "Liveness probe",
+		Responses: map[string]openAPIResponse{"200": jsonResponse("Process is up", "Health")},
+	},
+	"GET /readyz": {
+		Summary: "Readiness probe",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("All dependencies reachable", "Health"),
+			"503": jsonResponse("A dependency is unreachable", "Health"),
+		},
+	},
+	"GET /openapi.json": {
+		Summary: "This document",
+		Responses: map[string]openAPIResponse{"200": {
+			Description: "OpenAPI 3 document",
+			Content:     map[string]openAPIMedia{gin.MIMEJSON: {Schema: &openAPISchema{Type: "object"}}},
+		}},
+	},
+	"GET /" + SERVICE_NAME + "/post": {
+		Summary:    "Get a post",
+		Security:   cookieAuth,
+		Parameters: []openAPIParameter{postIDParam},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("The post", "Post"),
+		}, 400, 401, 404, 500, 503),
+	},
+	"POST /" + SERVICE_NAME + "/post": {
+		Summary:     "Create a post as the calling user",
+		Security:    cookieAuth,
+		RequestBody: postBody("CreatePostRequest"),
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": textResponse("ok"),
+		}, 400, 401, 409, 422, 500, 503),
+	},
+	"PUT /" + SERVICE_NAME + "/post": {
+		Summary:     "Update the caller's post, absent fields are unchanged",
+		Security:    cookieAuth,
+		Parameters:  []openAPIParameter{postIDParam},
+		RequestBody: postBody("UpdatePostRequest"),
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": textResponse("updated"),
+		}, 400, 401, 403, 404, 422, 500, 503),
+	},
+	"DELETE /" + SERVICE_NAME + "/post": {
+		Summary:    "Delete the caller's post",
+		Security:   cookieAuth,
+		Parameters: []openAPIParameter{postIDParam},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": textResponse("deleted"),
+		}, 400, 401, 403, 404, 500, 503),
+	},
+	"GET /" + SERVICE_NAME + "/allpost": {
+		Summary: "List post ids in the collection's natural order",
+		Parameters: []openAPIParameter{{
+			Name: "range", In: "query",
+			Schema: &openAPISchema{Type: "integer", Default: 8},
+		}},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("Post ids", "PostIDList"),
+		}, 500),
+	},
+	"GET /" + SERVICE_NAME + "/user/:name": {
+		Summary:  "List the ids of a user's posts",
+		Security: cookieAuth,
+		Parameters: []openAPIParameter{{
+			Name: "name", In: "path", Required: true,
+			Schema: &openAPISchema{Type: "string"},
+		}},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("Post ids", "PostIDList"),
+		}, 401, 500, 503),
+	},
+	"POST /internal/token/revoke": {
+		Summary: "Drop a token from the auth cache",
+		RequestBody: &openAPIRequestBody{
+			Required: true,
+			Content: map[string]openAPIMedia{gin.MIMEPOSTForm: {Schema: &openAPISchema{
+				Type:       "object",
+				Properties: map[string]*openAPISchema{"token": {Type: "string"}},
+				Required:   []string{"token"},
+			}}},
+		},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": textResponse("revoked"),
+		}, 400, 500, 501),
+	},
+	"POST /internal/post": {
+		Summary:     "Create a post on behalf of a user",
+		RequestBody: postBody("InternalCreatePostRequest"),
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": textResponse("ok"),
+		}, 400, 409, 422, 500),
+	},
+	"POST /internal/user-updated": {
+		Summary:     "Copy a user's new profile into all of their posts",
+		RequestBody: postBody("UserUpdatedRequest"),
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("Number of posts updated", "AuthorSyncResult"),
+		}, 400, 422, 500),
+	},
+	"DELETE /internal/user/:uid": {
+		Summary:  "Start removing a deleted account's posts in the background",
+		Security: serviceAuth,
+		Parameters: []openAPIParameter{uidParam, {
+			Name: "mode", In: "query",
+			Schema: &openAPISchema{Type: "string", Enum: []string{models.DeletionModeDelete, models.DeletionModeAnonymize}, Default: models.DeletionModeDelete},
+		}},
+		Responses: withErrors(map[string]openAPIResponse{
+			"202": jsonResponse("The deletion job", "UserDeletion"),
+		}, 400, 401, 500, 501),
+	},
+	"GET /internal/user/:uid/deletion": {
+		Summary:    "Progress of the newest deletion job of an account",
+		Security:   serviceAuth,
+		Parameters: []openAPIParameter{uidParam},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("The deletion job", "UserDeletion"),
+		}, 401, 404, 500, 501),
+	},
+	"GET /internal/user/:uid/export": {
+		Summary:    "Download all posts of a user as JSON lines",
+		Security:   serviceAuth,
+		Parameters: []openAPIParameter{uidParam, formatParam},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": exportResponse,
+		}, 400, 401, 500),
+	},
+	"GET /internal/posts/export": {
+		Summary:  "Download posts as JSON lines, optionally of one user or time range",
+		Security: serviceAuth,
+		Parameters: []openAPIParameter{
+			{Name: "uid", In: "query", Schema: &openAPISchema{Type: "string"}},
+			{Name: "from", In: "query", Description: "RFC 3339 time or date, inclusive", Schema: &openAPISchema{Type: "string"}},
+			{Name: "to", In: "query", Description: "RFC 3339 time or date, exclusive", Schema: &openAPISchema{Type: "string"}},
+			formatParam,
+		},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": exportResponse,
+		}, 400, 401, 500),
+	},
+	"POST /internal/posts/import": {
+		Summary:  "Import posts from JSON lines as the export writes them, keeping their ids",
+		Security: serviceAuth,
+		Parameters: []openAPIParameter{{
+			Name: "dry_run", In: "query", Description: "validate without writing anything",
+			Schema: &openAPISchema{Type: "boolean", Default: false},
+		}},
+		RequestBody: &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMedia{ndjsonContentType: {Schema: &openAPISchema{Type: "string"}}},
+		},
+		Responses: withErrors(map[string]openAPIResponse{
+			"200": jsonResponse("What happened to each line, invalid lines are left out", "ImportResult"),
+		}, 400, 401, 500),
+	},
+}
+
+var uidParam = openAPIParameter{
+	Name: "uid", In: "path", Required: true,
+	Schema: &openAPISchema{Type: "string"},
+}
+
+var formatParam = openAPIParameter{
+	Name: "format", In: "query",
+	Schema: &openAPISchema{Type: "string", Enum: []string{exportJSONL, exportZIP}, Default: exportJSONL},
+}
+
+var exportResponse = openAPIResponse{
+	Description: "One Post per line, zipped into posts.jsonl for the zip format",
+	Content: map[string]openAPIMedia{
+		ndjsonContentType: {Schema: &openAPISchema{Type: "string"}},
+		zipContentType:    {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+	},
+}
+
+// openAPIPath turns a gin path like /user/:name into /user/{name}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, ":*")
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool {
+			return r == '-' || r == '.' || r == '_'
+		}) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// buildOpenAPI documents the given routes. Routes without an entry in
+// routeDocs are left out.
+func buildOpenAPI(routes gin.RoutesInfo) *openAPIDocument {
+	create_schema := postBodySchema(reflect.TypeOf(createPostRequest{}))
+	update_schema := postBodySchema(reflect.TypeOf(updatePostRequest{}))
+	internal_schema := postBodySchema(reflect.TypeOf(internalCreatePostRequest{}))
+	internal_schema.Required = []string{"uid", "username"}
+	internal_schema.Properties["avatarurl"].Format = "uri"
+
+	user_updated_schema := schemaOf(reflect.TypeOf(userUpdatedRequest{}), false)
+	user_updated_schema.Required = []string{"uid"}
+	user_updated_schema.Properties["username"].MinLength = 1
+	user_updated_schema.Properties["avatarurl"].Format = "uri"
+
+	ids := &openAPISchema{Type: "array", Nullable: true, Items: &openAPISchema{Type: "string", Pattern: "^[0-9a-f]{24}$"}}
+
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: SERVICE_NAME, Version: openAPIVersion},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				"Post":                          schemaOf(reflect.TypeOf(models.Post{}), true),
+				"CreatePostRequest":             create_schema,
+				"CreatePostRequestForm":         formBodySchema(create_schema),
+				"UpdatePostRequest":             update_schema,
+				"UpdatePostRequestForm":         formBodySchema(update_schema),
+				"InternalCreatePostRequest":     internal_schema,
+				"InternalCreatePostRequestForm": formBodySchema(internal_schema),
+				"UserUpdatedRequest":            user_updated_schema,
+				"UserUpdatedRequestForm":        formBodySchema(user_updated_schema),
+				"AuthorSyncResult": {
+					Type:       "object",
+					Properties: map[string]*openAPISchema{"updated": {Type: "integer"}},
+					Required:   []string{"updated"},
+				},
+				"ImportResult": schemaOf(reflect.TypeOf(importResult{}), true),
+				"UserDeletion": schemaOf(reflect.TypeOf(models.UserDeletion{}), true),
+				"PostIDList": {
+					Type:       "object",
+					Properties: map[string]*openAPISchema{"results": ids},
+					Required:   []string{"results"},
+				},
+				"Health": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"status": {Type: "string"},
+						"checks": {Type: "object", AdditionalProperties: &openAPISchema{Type: "string"}},
+					},
+					Required: []string{"status"},
+				},
+				"Error": {
+					Type:       "object",
+					Properties: map[string]*openAPISchema{"error": schemaOf(reflect.TypeOf(apiError{}), true)},
+					Required:   []string{"error"},
+				},
+			},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"cookieAuth":  {Type: "apiKey", In: "cookie", Name: "token"},
+				"serviceAuth": {Type: "apiKey", In: "header", Name: serviceTokenHeader},
+			},
+		},
+	}
+
+	for _, route := range routes {
+		op, documented := routeDocs[route.Method+" "+route.Path]
+		if !documented {
+			continue
+		}
+		operation := *op
+		operation.OperationID = operationID(route.Method, route.Path)
+
+		path := openAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = &operation
+	}
+	return doc
+}
+
+// openAPIHandler serves the document for the router's routes, built on
+// the first request once every route is registered.
+func openAPIHandler(router *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var doc *openAPIDocument
+	return func(c *gin.Context) {
+		once.Do(func() {
+			doc = buildOpenAPI(router.Routes())
+		})
+		c.JSON(200, doc)
+	}
+}
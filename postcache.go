@@ -0,0 +1,212 @@
+package main
+
+import (
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/logging"
+	"github.com/vinhut/posted/models"
+	"github.com/vinhut/posted/services"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const postCachePrefix = "post:"
+
+func postCacheKey(postid string) string {
+	return postCachePrefix + postid
+}
+
+// postCacheEntry is what the cache holds for a post id. An entry without a
+// post caches that it doesn't exist.
+type postCacheEntry struct {
+	Post       json.RawMessage `json:"post,omitempty"`
+	FreshUntil time.Time       `json:"fresh_until"`
+}
+
+// postCache reads posts through Redis for GET post, over HTTP and gRPC.
+// Concurrent misses on a post share one database read, and with a stale
+// ttl an expired post is served while one request reads it again.
+type postCache struct {
+	cache  services.RedisService
+	postdb models.PostDatabase
+	cfg    config.PostCacheConfig
+	loads  singleflight.Group
+
+	mu sync.Mutex
+	// inflight holds the running load of each post, for Invalidate to
+	// mark
+	inflight map[string]*postLoad
+}
+
+// postLoad is a running database read of a post. A load that was
+// invalidated may have read the post from before the change, so what it
+// caches is dropped again.
+type postLoad struct {
+	invalidated bool
+}
+
+func newPostCache(cache services.RedisService, postdb models.PostDatabase, cfg config.PostCacheConfig) *postCache {
+	return &postCache{
+		cache:    cache,
+		postdb:   postdb,
+		cfg:      cfg,
+		inflight: make(map[string]*postLoad),
+	}
+}
+
+// Get returns the post as the JSON GET post answers with.
+func (posts *postCache) Get(ctx context.Context, postid string) ([]byte, error) {
+
+	span, sctx := opentracing.StartSpanFromContext(ctx, "get post from cache")
+	entry, cached := posts.read(sctx, postid)
+	span.Finish()
+
+	if cached && time.Now().Before(entry.FreshUntil) {
+		postCacheRequests.WithLabelValues("hit").Inc()
+		return entry.result()
+	}
+	if cached {
+		postCacheRequests.WithLabelValues("stale").Inc()
+		// refreshes of a post already running are joined, not repeated
+		posts.load(ctx, postid)
+		return entry.result()
+	}
+
+	postCacheRequests.WithLabelValues("miss").Inc()
+	select {
+	case loaded := <-posts.load(ctx, postid):
+		if loaded.Err != nil {
+			return nil, loaded.Err
+		}
+		return loaded.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached post, the next Get reads it again. A load
+// of the post running meanwhile doesn't put the old post back.
+func (posts *postCache) Invalidate(ctx context.Context, postid string) error {
+	posts.mu.Lock()
+	if load, loading := posts.inflight[postid]; loading {
+		load.invalidated = true
+	}
+	posts.mu.Unlock()
+	return posts.cache.Delete(ctx, postCacheKey(postid))
+}
+
+// read returns the cached entry of postid. Entries that can't be decoded
+// count as missing.
+func (posts *postCache) read(ctx context.Context, postid string) (postCacheEntry, bool) {
+	var entry postCacheEntry
+	value, err := posts.cache.Get(ctx, postCacheKey(postid))
+	if err != nil || json.Unmarshal([]byte(value), &entry) != nil || entry.FreshUntil.IsZero() {
+		return postCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// load reads postid from the database in the background and caches the
+// result. Concurrent loads of the same post share one read. The read is
+// shared, so it doesn't end with the request that started it but after
+// the load timeout.
+func (posts *postCache) load(ctx context.Context, postid string) <-chan singleflight.Result {
+	return posts.loads.DoChan(postid, func() (interface{}, error) {
+
+		ctx := detach(ctx)
+		if posts.cfg.LoadTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, posts.cfg.LoadTimeout)
+			defer cancel()
+		}
+
+		load := &postLoad{}
+		posts.mu.Lock()
+		posts.inflight[postid] = load
+		posts.mu.Unlock()
+		defer func() {
+			posts.mu.Lock()
+			delete(posts.inflight, postid)
+			invalidated := load.invalidated
+			posts.mu.Unlock()
+			// Invalidate marks the load before it deletes, so either its
+			// delete comes after the store or this one does.
+			if invalidated {
+				posts.cache.Delete(detach(ctx), postCacheKey(postid))
+			}
+		}()
+
+		span, sctx := opentracing.StartSpanFromContext(ctx, "find post by id")
+		post := &models.Post{}
+		find_err := posts.postdb.Find(sctx, "_id", postid, post)
+		span.Finish()
+
+		if errors.Is(find_err, models.ErrNotFound) && posts.cfg.NegativeTTL > 0 {
+			posts.store(ctx, postid, postCacheEntry{FreshUntil: time.Now().Add(posts.cfg.NegativeTTL)}, posts.cfg.NegativeTTL)
+		}
+		if find_err != nil {
+			return nil, find_err
+		}
+
+		post_json, json_err := json.Marshal(post)
+		if json_err != nil {
+			return nil, json_err
+		}
+		ttl := posts.cfg.TTL
+		if posts.cfg.Jitter > 0 {
+			ttl += time.Duration(rand.Int63n(int64(posts.cfg.Jitter)))
+		}
+		posts.store(ctx, postid, postCacheEntry{Post: post_json, FreshUntil: time.Now().Add(ttl)}, ttl+posts.cfg.StaleTTL)
+		return post_json, nil
+	})
+}
+
+// store caches entry for ttl. The post was read anyway, so a failure is
+// only logged.
+func (posts *postCache) store(ctx context.Context, postid string, entry postCacheEntry, ttl time.Duration) {
+	span, sctx := opentracing.StartSpanFromContext(ctx, "store post in cache")
+	defer span.Finish()
+	value, err := json.Marshal(entry)
+	if err == nil {
+		err = posts.cache.SetWithTTL(sctx, postCacheKey(postid), string(value), ttl)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("caching post failed", zap.String("postid", postid), zap.Error(err))
+	}
+}
+
+func (entry postCacheEntry) result() ([]byte, error) {
+	if len(entry.Post) == 0 {
+		return nil, models.ErrNotFound
+	}
+	return entry.Post, nil
+}
+
+// detachedContext keeps the values of a context, the request id and span,
+// but not its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
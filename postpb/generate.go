@@ -0,0 +1,6 @@
+// Package postpb holds the protobuf messages and gRPC stubs of
+// post-service, generated from post.proto with protoc-gen-go v1.26.0 and
+// protoc-gen-go-grpc v1.1.0.
+package postpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative post.proto
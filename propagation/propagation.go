@@ -0,0 +1,61 @@
+// Package propagation carries request ids and trace spans from one service
+// to the next. It only depends on opentracing so the client package can
+// use it without pulling in the service's logging.
+package propagation
+
+import (
+	"context"
+	"net/http"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, request_id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, request_id)
+}
+
+func RequestID(ctx context.Context) string {
+	request_id, _ := ctx.Value(requestIDKey{}).(string)
+	return request_id
+}
+
+// StartClientSpan opens a child span of the span carried by ctx. It returns
+// nil when ctx is not traced so background calls don't start new traces.
+func StartClientSpan(ctx context.Context, operation string) opentracing.Span {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	span := parent.Tracer().StartSpan(operation, opentracing.ChildOf(parent.Context()))
+	ext.SpanKindRPCClient.Set(span)
+	return span
+}
+
+// FinishClientSpan marks span as failed when err is set and finishes it.
+func FinishClientSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.String("event", "error"), otlog.Error(err))
+	}
+	span.Finish()
+}
+
+// InjectSpan starts a client span for an outgoing HTTP request and injects
+// it into the request headers so the callee joins the trace. It returns nil
+// when the request context isn't traced.
+func InjectSpan(req *http.Request, operation string) opentracing.Span {
+	span := StartClientSpan(req.Context(), operation)
+	if span == nil {
+		return nil
+	}
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	return span
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vinhut/posted/logging"
+	"github.com/vinhut/posted/propagation"
+	"go.uber.org/zap"
+
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+const maxRequestIDLength = 128
+
+// requestIDMiddleware keeps the caller's X-Request-ID, or makes one up, and
+// echoes it back so a request can be followed across services and logs.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		request_id := c.GetHeader(propagation.RequestIDHeader)
+		if !validRequestID(request_id) {
+			request_id = newRequestID()
+		}
+
+		c.Header(propagation.RequestIDHeader, request_id)
+		c.Request = c.Request.WithContext(propagation.WithRequestID(c.Request.Context(), request_id))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validRequestID rejects ids that are empty, oversized or carry characters
+// that have no business in a log line.
+func validRequestID(request_id string) bool {
+	if request_id == "" || len(request_id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range request_id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLogMiddleware writes one JSON line per request in place of gin's
+// text logger. It runs inside the tracing middleware so the trace id is known.
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		start := time.Now()
+		c.Next()
+
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", c.FullPath()),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		logger := logging.FromContext(c.Request.Context())
+		if c.Writer.Status() >= 500 {
+			logger.Error("request", fields...)
+		} else {
+			logger.Info("request", fields...)
+		}
+	}
+}
+
+// recoveryMiddleware turns a panicking handler into a 500 error response and
+// logs the panic with the request's ids instead of gin's plain text dump.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				logging.FromContext(c.Request.Context()).Error("panic in handler",
+					zap.String("panic", fmt.Sprint(r)),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				abortWithError(c, fmt.Errorf("panic: %v", r))
+			}
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxCaptionLength = 2200
+	maxTags          = 30
+	maxTagLength     = 50
+	maxURLLength     = 2048
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+
+// fieldError is one failed validation rule, reported back to the client.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError carries every field that failed validation so clients can
+// fix them all in one round trip. It is answered with 422.
+type validationError struct {
+	Fields []fieldError
+}
+
+func (err *validationError) Error() string {
+	messages := make([]string, len(err.Fields))
+	for i, field := range err.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "validation failed: " + strings.Join(messages, ", ")
+}
+
+func (err *validationError) add(field, format string, args ...interface{}) {
+	err.Fields = append(err.Fields, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (err *validationError) orNil() error {
+	if len(err.Fields) == 0 {
+		return nil
+	}
+	return err
+}
+
+// createPostRequest is the body of POST post-service/post. It is read from
+// JSON or, for older clients, from form fields with comma separated tags.
+// Only JSON bodies are validated: form bodies are taken as they always
+// were, so the clients still sending them don't start failing.
+type createPostRequest struct {
+	ImgURL  string   `json:"img_url"`
+	Caption string   `json:"post_caption"`
+	Tags    []string `json:"tags"`
+}
+
+// internalCreatePostRequest is the body of POST internal/post, where the
+// calling service supplies the author instead of a token. Like
+// createPostRequest, form bodies aren't validated.
+type internalCreatePostRequest struct {
+	createPostRequest
+	Uid        string `json:"uid"`
+	Username   string `json:"username"`
+	Screenname string `json:"screenname"`
+	Avatarurl  string `json:"avatarurl"`
+}
+
+// updatePostRequest is the body of PUT post-service/post. Absent fields
+// are left unchanged.
+type updatePostRequest struct {
+	ImgURL  *string   `json:"img_url"`
+	Caption *string   `json:"post_caption"`
+	Tags    *[]string `json:"tags"`
+}
+
+// userUpdatedRequest is the body of POST internal/user-updated, sent by
+// the user service when a profile changes. Absent fields are left
+// unchanged in the user's posts.
+type userUpdatedRequest struct {
+	Uid        string  `json:"uid"`
+	Username   *string `json:"username"`
+	Screenname *string `json:"screenname"`
+	Avatarurl  *string `json:"avatarurl"`
+	Verified   *bool   `json:"verified"`
+}
+
+func isJSON(c *gin.Context) bool {
+	return c.ContentType() == gin.MIMEJSON
+}
+
+func bindJSON(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindJSON(req); err != nil {
+		return fmt.Errorf("%w: malformed json body: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// splitTags turns the comma separated form value into a tag list.
+func splitTags(tags string) []string {
+	post_tags := make([]string, 0)
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			post_tags = append(post_tags, tag)
+		}
+	}
+	return post_tags
+}
+
+func (req *createPostRequest) bind(c *gin.Context) error {
+	if isJSON(c) {
+		if err := bindJSON(c, req); err != nil {
+			return err
+		}
+	} else {
+		req.ImgURL = c.PostForm("img_url")
+		req.Caption = c.PostForm("post_caption")
+		req.Tags = splitTags(c.PostForm("tags"))
+		return nil
+	}
+	if req.Tags == nil {
+		req.Tags = make([]string, 0)
+	}
+	return req.validate()
+}
+
+func (req *createPostRequest) validate() error {
+	errs := &validationError{}
+	validateImageURL(errs, req.ImgURL)
+	validateCaption(errs, req.Caption)
+	validateTags(errs, req.Tags)
+	return errs.orNil()
+}
+
+func (req *internalCreatePostRequest) bind(c *gin.Context) error {
+	if isJSON(c) {
+		if err := bindJSON(c, req); err != nil {
+			return err
+		}
+	} else {
+		req.ImgURL = c.PostForm("img_url")
+		req.Caption = c.PostForm("post_caption")
+		req.Tags = splitTags(c.PostForm("tags"))
+		req.Uid = c.PostForm("uid")
+		req.Username = c.PostForm("username")
+		req.Screenname = c.PostForm("screenname")
+		req.Avatarurl = c.PostForm("avatarurl")
+		return nil
+	}
+	if req.Tags == nil {
+		req.Tags = make([]string, 0)
+	}
+	return req.validate()
+}
+
+func (req *internalCreatePostRequest) validate() error {
+	errs := &validationError{}
+	validateImageURL(errs, req.ImgURL)
+	validateCaption(errs, req.Caption)
+	validateTags(errs, req.Tags)
+	if req.Uid == "" {
+		errs.add("uid", "is required")
+	}
+	if req.Username == "" {
+		errs.add("username", "is required")
+	}
+	if req.Avatarurl != "" {
+		validateURL(errs, "avatarurl", req.Avatarurl)
+	}
+	return errs.orNil()
+}
+
+func (req *updatePostRequest) bind(c *gin.Context) error {
+	if isJSON(c) {
+		if err := bindJSON(c, req); err != nil {
+			return err
+		}
+	} else {
+		if img_url, ok := c.GetPostForm("img_url"); ok {
+			req.ImgURL = &img_url
+		}
+		if caption, ok := c.GetPostForm("post_caption"); ok {
+			req.Caption = &caption
+		}
+		if tags, ok := c.GetPostForm("tags"); ok {
+			post_tags := splitTags(tags)
+			req.Tags = &post_tags
+		}
+	}
+
+	errs := &validationError{}
+	if req.ImgURL == nil && req.Caption == nil && req.Tags == nil {
+		errs.add("body", "at least one of img_url, post_caption or tags is required")
+	}
+	if req.ImgURL != nil {
+		validateImageURL(errs, *req.ImgURL)
+	}
+	if req.Caption != nil {
+		validateCaption(errs, *req.Caption)
+	}
+	if req.Tags != nil {
+		validateTags(errs, *req.Tags)
+	}
+	return errs.orNil()
+}
+
+func (req *userUpdatedRequest) bind(c *gin.Context) error {
+	errs := &validationError{}
+	if isJSON(c) {
+		if err := bindJSON(c, req); err != nil {
+			return err
+		}
+	} else {
+		req.Uid = c.PostForm("uid")
+		if username, ok := c.GetPostForm("username"); ok {
+			req.Username = &username
+		}
+		if screenname, ok := c.GetPostForm("screenname"); ok {
+			req.Screenname = &screenname
+		}
+		if avatarurl, ok := c.GetPostForm("avatarurl"); ok {
+			req.Avatarurl = &avatarurl
+		}
+		if verified, ok := c.GetPostForm("verified"); ok {
+			parsed, err := strconv.ParseBool(verified)
+			if err != nil {
+				errs.add("verified", "must be true or false")
+			}
+			req.Verified = &parsed
+		}
+	}
+
+	if req.Uid == "" {
+		errs.add("uid", "is required")
+	}
+	if req.Username == nil && req.Screenname == nil && req.Avatarurl == nil && req.Verified == nil {
+		errs.add("body", "at least one of username, screenname, avatarurl or verified is required")
+	}
+	if req.Username != nil && *req.Username == "" {
+		errs.add("username", "must not be empty")
+	}
+	if req.Avatarurl != nil && *req.Avatarurl != "" {
+		validateURL(errs, "avatarurl", *req.Avatarurl)
+	}
+	return errs.orNil()
+}
+
+// validateImageURL accepts an empty image url, posts have always been
+// allowed without one.
+func validateImageURL(errs *validationError, img_url string) {
+	if img_url != "" {
+		validateURL(errs, "img_url", img_url)
+	}
+}
+
+func validateURL(errs *validationError, field, raw string) {
+	if len(raw) > maxURLLength {
+		errs.add(field, "must be at most %d characters", maxURLLength)
+		return
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		errs.add(field, "must be an absolute url")
+		return
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		errs.add(field, "must use the http or https scheme")
+	}
+}
+
+func validateCaption(errs *validationError, caption string) {
+	if utf8.RuneCountInString(caption) > maxCaptionLength {
+		errs.add("post_caption", "must be at most %d characters", maxCaptionLength)
+	}
+}
+
+func validateTags(errs *validationError, tags []string) {
+	if len(tags) > maxTags {
+		errs.add("tags", "must have at most %d tags", maxTags)
+	}
+	for i, tag := range tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			errs.add(fmt.Sprintf("tags[%d]", i), "must be at most %d characters", maxTagLength)
+		} else if !tagPattern.MatchString(tag) {
+			errs.add(fmt.Sprintf("tags[%d]", i), "may only contain letters, digits and underscores")
+		}
+	}
+}
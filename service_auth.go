@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vinhut/posted/services"
+
+	"crypto/subtle"
+)
+
+// serviceTokenHeader carries the token other services authenticate with,
+// as an HTTP header or gRPC metadata.
+const serviceTokenHeader = "X-Service-Token"
+
+// checkServiceToken accepts presented if it is the configured service
+// token. Without one configured nothing is accepted.
+func checkServiceToken(expected, presented string) error {
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(presented)) != 1 {
+		return services.ErrUnauthorized
+	}
+	return nil
+}
+
+// requireServiceToken lets only requests presenting token in the
+// serviceTokenHeader through.
+func requireServiceToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checkServiceToken(token, c.GetHeader(serviceTokenHeader)); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,83 +1,210 @@
 package services
 
 import (
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"net/http"
 	"net/url"
 
 	"io/ioutil"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/propagation"
 )
 
-var SERVICE_URL = os.Getenv("AUTH_SERVICE_URL")
+var (
+	// ErrUnauthorized means the auth service looked at the credentials and
+	// rejected them.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrAuthUnavailable means no answer could be had from the auth service:
+	// it timed out, returned a server error or the circuit breaker is open.
+	ErrAuthUnavailable = errors.New("auth service unavailable")
+)
 
 type AuthService interface {
-	Login(service string, email string, password string) (string, error)
-	Check(service string, token string) (string, error)
-	Update() (bool, error)
-	Create(service string, email string, password string) (bool, error)
-	Delete(string) (bool, error)
+	Login(ctx context.Context, service string, email string, password string) (string, error)
+	Check(ctx context.Context, service string, token string) (string, error)
+	Update(ctx context.Context) (bool, error)
+	Create(ctx context.Context, service string, email string, password string) (bool, error)
+	Delete(context.Context, string) (bool, error)
+	Ping(context.Context) error
 }
 
 type userAuthService struct {
-	token string
+	token   string
+	url     string
+	client  *http.Client
+	cfg     config.AuthConfig
+	breaker *circuitBreaker
 }
 
-func NewUserAuthService() AuthService {
+// NewUserAuthService talks to the auth service at cfg.ServiceURL. Only
+// idempotent calls (Check) are retried.
+func NewUserAuthService(cfg config.AuthConfig) AuthService {
 	return &userAuthService{
-		token: "",
+		token:   "",
+		url:     cfg.ServiceURL,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		cfg:     cfg,
+		breaker: newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// do sends the request built by newRequest and returns the response body of
+// a 200 answer. 4xx answers become ErrUnauthorized, everything that points
+// at the auth service being down becomes ErrAuthUnavailable and counts
+// against the circuit breaker.
+func (userAuth *userAuthService) do(ctx context.Context, endpoint string, newRequest func(context.Context) (*http.Request, error), idempotent bool) ([]byte, error) {
+
+	attempts := 1
+	if idempotent {
+		attempts += userAuth.cfg.MaxRetries
+	}
+
+	var last_err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(userAuth.cfg.RetryBackoff * time.Duration(attempt)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if !userAuth.breaker.Allow() {
+			authFailures.WithLabelValues(endpoint, "circuit_open").Inc()
+			return nil, fmt.Errorf("%w: circuit open", ErrAuthUnavailable)
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			userAuth.breaker.Abandon()
+			return nil, err
+		}
+
+		start := time.Now()
+		body, status, err := userAuth.send(req)
+		authDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// the caller gave up, that says nothing about the auth service
+			userAuth.breaker.Abandon()
+			return nil, ctx.Err()
+		case err != nil:
+			authFailures.WithLabelValues(endpoint, "transport").Inc()
+			userAuth.breaker.Failure()
+			last_err = fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
+		case status == http.StatusOK:
+			userAuth.breaker.Success()
+			return body, nil
+		case status >= 500:
+			authFailures.WithLabelValues(endpoint, "server_error").Inc()
+			userAuth.breaker.Failure()
+			last_err = fmt.Errorf("%w: status %d", ErrAuthUnavailable, status)
+		default:
+			userAuth.breaker.Success()
+			return nil, fmt.Errorf("%w: status %d", ErrUnauthorized, status)
+		}
 	}
+	return nil, last_err
 }
 
-func (userAuth *userAuthService) Login(service, email, password string) (string, error) {
-	resp, err := http.PostForm(SERVICE_URL+"/login",
-		url.Values{"service": {service}, "email": {email}, "password": {password}})
+func (userAuth *userAuthService) send(req *http.Request) ([]byte, int, error) {
+	span := propagation.InjectSpan(req, "auth-service "+req.Method+" "+req.URL.Path)
+
+	body, status, err := userAuth.roundTrip(req)
+	if span != nil {
+		if status != 0 {
+			ext.HTTPStatusCode.Set(span, uint16(status))
+		}
+		if err == nil && status >= 500 {
+			ext.Error.Set(span, true)
+		}
+		propagation.FinishClientSpan(span, err)
+	}
+	return body, status, err
+}
+
+func (userAuth *userAuthService) roundTrip(req *http.Request) ([]byte, int, error) {
+	resp, err := userAuth.client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return string(body), nil
-	} else {
-		return "", err
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
 	}
+	return body, resp.StatusCode, nil
+}
 
+func (userAuth *userAuthService) postForm(path string, form url.Values) func(context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", userAuth.url+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
 }
 
-func (userAuth *userAuthService) Check(service, token string) (string, error) {
-	resp, err := http.Get(SERVICE_URL + "/user?service=" + service + "&token=" + token)
+func (userAuth *userAuthService) Login(ctx context.Context, service, email, password string) (string, error) {
+	body, err := userAuth.do(ctx, "POST /login", userAuth.postForm("/login",
+		url.Values{"service": {service}, "email": {email}, "password": {password}}), false)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return string(body), nil
-	} else {
+	return string(body), nil
+}
+
+func (userAuth *userAuthService) Check(ctx context.Context, service, token string) (string, error) {
+	query := url.Values{"service": {service}, "token": {token}}
+	body, err := userAuth.do(ctx, "GET /user", func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", userAuth.url+"/user?"+query.Encode(), nil)
+	}, true)
+	if err != nil {
 		return "", err
 	}
+	return string(body), nil
 }
 
-func (userAuth *userAuthService) Update() (bool, error) {
+func (userAuth *userAuthService) Update(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (userAuth *userAuthService) Create(service, email, password string) (bool, error) {
-	resp, err := http.PostForm(SERVICE_URL+"/user",
-		url.Values{"service": {service}, "email": {email}, "password": {password}})
+func (userAuth *userAuthService) Create(ctx context.Context, service, email, password string) (bool, error) {
+	_, err := userAuth.do(ctx, "POST /user", userAuth.postForm("/user",
+		url.Values{"service": {service}, "email": {email}, "password": {password}}), false)
 	if err != nil {
 		return false, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		return true, nil
-	} else {
-		return false, err
-	}
-
+	return true, nil
 }
 
-func (userAuth *userAuthService) Delete(string) (bool, error) {
+func (userAuth *userAuthService) Delete(context.Context, string) (bool, error) {
 	return false, nil
 }
+
+// Ping checks the auth service is up. It bypasses retries and the circuit
+// breaker so readiness reflects the service itself.
+func (userAuth *userAuthService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", userAuth.url+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	_, status, err := userAuth.send(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: status %d", ErrAuthUnavailable, status)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	authCacheSize   = 4096
+	authCachePrefix = "auth:"
+)
+
+// TokenRevoker is implemented by auth services that can drop a cached
+// token check before its TTL runs out, e.g. on logout.
+type TokenRevoker interface {
+	Revoke(ctx context.Context, service string, token string) error
+}
+
+// cachedAuthService wraps another AuthService and remembers Check results
+// in cache, which is meant to be a NewAuthCache. Rejected tokens are cached
+// too, for a shorter negative TTL. Only Check is cached, every other call
+// is passed through to the wrapped service.
+type cachedAuthService struct {
+	AuthService
+	cache        RedisService
+	ttl          time.Duration
+	negative_ttl time.Duration
+}
+
+func NewCachedAuthService(auth AuthService, cache RedisService, ttl, negative_ttl time.Duration) AuthService {
+	return &cachedAuthService{
+		AuthService:  auth,
+		cache:        cache,
+		ttl:          ttl,
+		negative_ttl: negative_ttl,
+	}
+}
+
+// NewAuthCache puts an in-process LRU in front of remote for token checks.
+// A revoked token is evicted on every instance that Runs it, so revoking
+// works across replicas. Entries read from Redis stay local for ttl, rejected
+// tokens included.
+func NewAuthCache(remote RedisService, ttl time.Duration, channel string) *LayeredRedisService {
+	return NewLayeredRedisService(remote, authCacheSize, ttl, channel)
+}
+
+// authCacheKey hashes the token so raw credentials never end up as Redis keys.
+func authCacheKey(service, token string) string {
+	sum := sha256.Sum256([]byte(service + ":" + token))
+	return authCachePrefix + hex.EncodeToString(sum[:])
+}
+
+func (cached *cachedAuthService) Check(ctx context.Context, service, token string) (string, error) {
+
+	key := authCacheKey(service, token)
+	if user_data, cache_err := cached.cache.Get(ctx, key); cache_err == nil {
+		return cachedResult(user_data)
+	}
+
+	user_data, err := cached.AuthService.Check(ctx, service, token)
+	if err != nil && !errors.Is(err, ErrUnauthorized) {
+		// An unavailable auth service says nothing about the token,
+		// don't cache it.
+		return user_data, err
+	}
+
+	cached.cache.SetWithTTL(ctx, key, user_data, cached.entryTTL(user_data))
+	return cachedResult(user_data)
+}
+
+// cachedResult turns a cache entry back into a Check result. An empty entry
+// is a negative result for a token the auth service rejected.
+func cachedResult(user_data string) (string, error) {
+	if user_data == "" {
+		return "", ErrUnauthorized
+	}
+	return user_data, nil
+}
+
+func (cached *cachedAuthService) Revoke(ctx context.Context, service, token string) error {
+	return cached.cache.Delete(ctx, authCacheKey(service, token))
+}
+
+func (cached *cachedAuthService) entryTTL(user_data string) time.Duration {
+	if user_data == "" {
+		return cached.negative_ttl
+	}
+	return cached.ttl
+}
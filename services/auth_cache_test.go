@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/config"
+	mocks_services "github.com/vinhut/posted/mocks_services"
+)
+
+// testService is the service name token checks are made for.
+const testService = "post-service"
+
+func newTestAuthCache(remote RedisService) *LayeredRedisService {
+	auth_cfg := config.Default().Auth
+	return NewAuthCache(remote, auth_cfg.CacheTTL, auth_cfg.CacheInvalidationChannel)
+}
+
+func newTestCachedAuth(auth AuthService, cache RedisService) AuthService {
+	auth_cfg := config.Default().Auth
+	return NewCachedAuthService(auth, cache, auth_cfg.CacheTTL, auth_cfg.NegativeCacheTTL)
+}
+
+func TestCachedAuthCheck(t *testing.T) {
+
+	user_data := `{"uid": "1", "username": "test_email"}`
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock_auth := mocks_services.NewMockAuthService(ctrl)
+	mock_redis := mocks_services.NewMockRedisService(ctrl)
+
+	mock_redis.EXPECT().GetWithTTL(gomock.Any(), authCacheKey(testService, "token")).Return("", time.Duration(0), errors.New("mock error"))
+	mock_auth.EXPECT().Check(gomock.Any(), testService, "token").Return(user_data, nil)
+	mock_redis.EXPECT().SetWithTTL(gomock.Any(), authCacheKey(testService, "token"), user_data, config.Default().Auth.CacheTTL).Return(nil)
+
+	authservice := newTestCachedAuth(mock_auth, newTestAuthCache(mock_redis))
+
+	// the second check is answered by the process
+	for i := 0; i < 2; i++ {
+		checked, err := authservice.Check(context.Background(), testService, "token")
+		assert.NoError(t, err)
+		assert.Equal(t, user_data, checked)
+	}
+
+}
+
+func TestCachedAuthNegativeAndRevoke(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock_auth := mocks_services.NewMockAuthService(ctrl)
+	mock_redis := mocks_services.NewMockRedisService(ctrl)
+
+	mock_redis.EXPECT().GetWithTTL(gomock.Any(), gomock.Any()).Return("", time.Duration(0), errors.New("mock error")).Times(2)
+	mock_auth.EXPECT().Check(gomock.Any(), testService, "token").Return("", ErrUnauthorized).Times(2)
+	mock_redis.EXPECT().SetWithTTL(gomock.Any(), gomock.Any(), "", config.Default().Auth.NegativeCacheTTL).Return(nil).Times(2)
+	mock_redis.EXPECT().Delete(gomock.Any(), authCacheKey(testService, "token")).Return(nil)
+	mock_redis.EXPECT().Publish(gomock.Any(), config.Default().Auth.CacheInvalidationChannel, authCacheKey(testService, "token")).Return(nil)
+
+	authservice := newTestCachedAuth(mock_auth, newTestAuthCache(mock_redis))
+
+	// a rejected token is served from the negative cache on the second check
+	authservice.Check(context.Background(), testService, "token")
+	user_data, err := authservice.Check(context.Background(), testService, "token")
+	assert.Equal(t, "", user_data)
+	assert.Equal(t, ErrUnauthorized, err)
+
+	// after revoking, the auth service is asked again
+	assert.NoError(t, authservice.(TokenRevoker).Revoke(context.Background(), testService, "token"))
+	authservice.Check(context.Background(), testService, "token")
+
+}
+
+func TestRevokeAcrossReplicas(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock_auth := mocks_services.NewMockAuthService(ctrl)
+	gomock.InOrder(
+		mock_auth.EXPECT().Check(gomock.Any(), testService, "token").Return("{}", nil),
+		mock_auth.EXPECT().Check(gomock.Any(), testService, "token").Return("", ErrUnauthorized).AnyTimes(),
+	)
+
+	remote := NewMemoryRedisService()
+	replica := func() AuthService {
+		auth_cache := newTestAuthCache(remote)
+		go auth_cache.Run(ctx)
+		return newTestCachedAuth(mock_auth, auth_cache)
+	}
+	replica_a, replica_b := replica(), replica()
+
+	_, err := replica_a.Check(ctx, testService, "token")
+	assert.NoError(t, err)
+	_, err = replica_b.Check(ctx, testService, "token")
+	assert.NoError(t, err)
+
+	// the other replica drops its local copy too
+	assert.NoError(t, replica_a.(TokenRevoker).Revoke(ctx, testService, "token"))
+	assert.Eventually(t, func() bool {
+		_, err := replica_b.Check(ctx, testService, "token")
+		return errors.Is(err, ErrUnauthorized)
+	}, time.Second, 10*time.Millisecond)
+
+}
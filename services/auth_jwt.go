@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinhut/posted/config"
+)
+
+var (
+	ErrInvalidToken = fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	ErrTokenExpired = fmt.Errorf("%w: token expired", ErrUnauthorized)
+	ErrNoJWTKeys    = errors.New("no jwt verification keys configured")
+)
+
+// jwtAuthService verifies signed JWTs locally instead of asking the auth
+// service on every request. Tokens must be signed with HS256 or RS256, carry
+// an exp claim and list the calling service in aud. Everything except Check
+// still goes to the wrapped AuthService.
+type jwtAuthService struct {
+	AuthService
+	hmac_keys map[string][]byte
+	rsa_keys  map[string]*rsa.PublicKey
+	now       func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// NewJWTAuthService loads the verification keys in cfg: an HMAC secret for
+// HS256, a PEM public key for RS256 and a JSON Web Key Set file holding
+// either kind, selected by the token kid.
+func NewJWTAuthService(auth AuthService, cfg config.JWTConfig) (AuthService, error) {
+	jwtAuth := &jwtAuthService{
+		AuthService: auth,
+		hmac_keys:   make(map[string][]byte),
+		rsa_keys:    make(map[string]*rsa.PublicKey),
+		now:         time.Now,
+	}
+
+	if cfg.HMACSecret != "" {
+		jwtAuth.hmac_keys[""] = []byte(cfg.HMACSecret)
+	}
+
+	if cfg.PublicKey != "" {
+		key, err := parseRSAPublicKey([]byte(cfg.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		jwtAuth.rsa_keys[""] = key
+	}
+
+	if cfg.JWKSFile != "" {
+		if err := jwtAuth.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(jwtAuth.hmac_keys) == 0 && len(jwtAuth.rsa_keys) == 0 {
+		return nil, ErrNoJWTKeys
+	}
+	return jwtAuth, nil
+}
+
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsa_key, ok := key.(*rsa.PublicKey); ok {
+			return rsa_key, nil
+		}
+		return nil, errors.New("jwt public key is not an RSA key")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+func (jwtAuth *jwtAuthService) loadJWKS(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return err
+	}
+
+	for _, jwk := range jwks.Keys {
+		switch jwk.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return err
+			}
+			jwtAuth.hmac_keys[jwk.Kid] = secret
+		case "RSA":
+			n, n_err := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, e_err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if n_err != nil || e_err != nil {
+				return errors.New("malformed RSA key " + jwk.Kid + " in jwks")
+			}
+			jwtAuth.rsa_keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		}
+	}
+	return nil
+}
+
+// Check verifies the token and returns its claims in the same shape the
+// auth service's /user endpoint answers with. The service name is the
+// audience the token has to be issued for.
+func (jwtAuth *jwtAuthService) Check(ctx context.Context, service, token string) (string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	signature, sig_err := base64.RawURLEncoding.DecodeString(parts[2])
+	if sig_err != nil {
+		return "", ErrInvalidToken
+	}
+	if err := jwtAuth.verify(header, parts[0]+"."+parts[1], signature); err != nil {
+		return "", err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+	if err := jwtAuth.validateClaims(service, claims); err != nil {
+		return "", err
+	}
+
+	user_data, err := json.Marshal(userFromClaims(claims))
+	if err != nil {
+		return "", err
+	}
+	return string(user_data), nil
+}
+
+// Ping always succeeds, tokens are verified without the auth service.
+func (jwtAuth *jwtAuthService) Ping(ctx context.Context) error {
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (jwtAuth *jwtAuthService) verify(header jwtHeader, signed string, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		key, ok := jwtAuth.hmac_keys[header.Kid]
+		if !ok {
+			return ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalidToken
+		}
+		return nil
+	case "RS256":
+		key, ok := jwtAuth.rsa_keys[header.Kid]
+		if !ok {
+			return ErrInvalidToken
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	default:
+		return ErrInvalidToken
+	}
+}
+
+func (jwtAuth *jwtAuthService) validateClaims(audience string, claims map[string]interface{}) error {
+	now := jwtAuth.now().Unix()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return ErrInvalidToken
+	}
+	if now >= int64(exp) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return ErrInvalidToken
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == audience {
+			return nil
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidToken
+}
+
+// userFromClaims maps token claims onto the user payload handlers expect.
+// The auth service sends every field as a string, verified included.
+func userFromClaims(claims map[string]interface{}) map[string]string {
+	user := make(map[string]string)
+	for _, field := range []string{"uid", "email", "role", "username", "screenname", "avatarurl"} {
+		if value, ok := claims[field].(string); ok {
+			user[field] = value
+		} else {
+			user[field] = ""
+		}
+	}
+	if user["uid"] == "" {
+		if sub, ok := claims["sub"].(string); ok {
+			user["uid"] = sub
+		}
+	}
+
+	switch verified := claims["verified"].(type) {
+	case bool:
+		user["verified"] = strconv.FormatBool(verified)
+	case string:
+		user["verified"] = verified
+	default:
+		user["verified"] = "false"
+	}
+	return user
+}
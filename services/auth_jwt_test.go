@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/config"
+)
+
+func signTestJWT(header, claims map[string]interface{}, sign func([]byte) []byte) string {
+	header_json, _ := json.Marshal(header)
+	claims_json, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header_json) + "." + base64.RawURLEncoding.EncodeToString(claims_json)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+func TestJWTCheckUser(t *testing.T) {
+
+	secret := []byte("12345678901234567890123456789012")
+	rsa_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	public_der, _ := x509.MarshalPKIXPublicKey(&rsa_key.PublicKey)
+
+	jwt_cfg := config.JWTConfig{
+		HMACSecret: string(secret),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public_der})),
+	}
+
+	authservice, err := NewJWTAuthService(NewUserAuthService(config.Default().Auth), jwt_cfg)
+	assert.Nil(t, err)
+
+	hs256 := func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+	rs256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsa_key, crypto.SHA256, digest[:])
+		return signature
+	}
+	claims := map[string]interface{}{
+		"sub":        "1",
+		"username":   "test_email",
+		"screenname": "test_email",
+		"avatarurl":  "http://localhost/img.png",
+		"verified":   true,
+		"aud":        testService,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	}
+
+	check := func(token string) (map[string]string, error) {
+		user_data, err := authservice.Check(context.Background(), testService, token)
+		if err != nil {
+			return nil, err
+		}
+		data := map[string]string{}
+		err = json.Unmarshal([]byte(user_data), &data)
+		return data, err
+	}
+
+	for alg, sign := range map[string]func([]byte) []byte{"HS256": hs256, "RS256": rs256} {
+		token := signTestJWT(map[string]interface{}{"alg": alg, "typ": "JWT"}, claims, sign)
+		data, check_err := check(token)
+		assert.Nil(t, check_err, alg)
+		assert.Equal(t, "1", data["uid"], alg)
+		assert.Equal(t, "test_email", data["username"], alg)
+		assert.Equal(t, "true", data["verified"], alg)
+	}
+
+	claims["aud"] = "other-service"
+	_, aud_err := check(signTestJWT(map[string]interface{}{"alg": "HS256"}, claims, hs256))
+	assert.Equal(t, ErrInvalidToken, aud_err)
+
+	claims["aud"] = testService
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, exp_err := check(signTestJWT(map[string]interface{}{"alg": "HS256"}, claims, hs256))
+	assert.Equal(t, ErrTokenExpired, exp_err)
+
+}
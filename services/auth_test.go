@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/config"
+)
+
+func TestUserAuthServiceErrors(t *testing.T) {
+
+	token := "852a37a34b727c0e0b331806"
+	status := 401
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	auth_cfg := config.Default().Auth
+	auth_cfg.ServiceURL = server.URL
+	auth_cfg.RetryBackoff = time.Millisecond
+	auth_cfg.BreakerThreshold = 3
+	auth_cfg.BreakerCooldown = time.Minute
+	authservice := NewUserAuthService(auth_cfg)
+
+	_, unauthorized_err := authservice.Check(context.Background(), testService, token)
+	assert.True(t, errors.Is(unauthorized_err, ErrUnauthorized))
+	assert.Equal(t, 1, calls)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, cancel_err := authservice.Check(cancelled, testService, token)
+	assert.True(t, errors.Is(cancel_err, context.Canceled))
+	assert.Equal(t, 1, calls)
+
+	// server errors are retried, then the breaker opens
+	status = 503
+	calls = 0
+	_, unavailable_err := authservice.Check(context.Background(), testService, token)
+	assert.True(t, errors.Is(unavailable_err, ErrAuthUnavailable))
+	assert.Equal(t, 1+auth_cfg.MaxRetries, calls)
+
+	calls = 0
+	_, open_err := authservice.Check(context.Background(), testService, token)
+	assert.True(t, errors.Is(open_err, ErrAuthUnavailable))
+	assert.Equal(t, 0, calls)
+
+}
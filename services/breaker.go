@@ -0,0 +1,80 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens and rejects calls until cooldown has passed,
+// then lets a single trial call through to decide whether to close again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	opened_at time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		state:     breakerClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a call may go through right now.
+func (breaker *circuitBreaker) Allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case breakerOpen:
+		if time.Since(breaker.opened_at) < breaker.cooldown {
+			return false
+		}
+		breaker.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// a trial call is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (breaker *circuitBreaker) Success() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.state = breakerClosed
+	breaker.failures = 0
+}
+
+func (breaker *circuitBreaker) Failure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.failures++
+	if breaker.state == breakerHalfOpen || breaker.failures >= breaker.threshold {
+		breaker.state = breakerOpen
+		breaker.opened_at = time.Now()
+	}
+}
+
+// Abandon is called when a call gave no verdict on the dependency, e.g. the
+// caller went away. A pending trial call is handed to the next caller.
+func (breaker *circuitBreaker) Abandon() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if breaker.state == breakerHalfOpen {
+		breaker.state = breakerOpen
+	}
+}
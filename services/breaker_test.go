@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	breaker := newCircuitBreaker(2, 20*time.Millisecond)
+
+	// a success resets the failure count
+	breaker.Failure()
+	breaker.Success()
+	breaker.Failure()
+	assert.True(t, breaker.Allow())
+
+	breaker.Failure()
+	assert.False(t, breaker.Allow())
+
+	// after the cooldown a single trial goes through
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+
+	// an abandoned trial leaves the breaker open for the next caller
+	breaker.Abandon()
+	assert.True(t, breaker.Allow())
+
+	// a failed trial opens it again
+	breaker.Failure()
+	assert.False(t, breaker.Allow())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	breaker.Success()
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+
+}
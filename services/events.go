@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vinhut/posted/config"
+	"github.com/vinhut/posted/events"
+)
+
+type redisEventPublisher struct {
+	client *redis.Client
+	stream string
+	maxlen int64
+}
+
+// NewRedisEventPublisher appends events to a Redis stream, trimmed to
+// about maxlen entries. Each entry carries the event id for consumers to
+// deduplicate on, its type, the post id and the event as JSON.
+func NewRedisEventPublisher(cfg config.RedisConfig, stream string, maxlen int) events.EventPublisher {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	rdb.AddHook(redisTracingHook{})
+	return &redisEventPublisher{
+		client: rdb,
+		stream: stream,
+		maxlen: int64(maxlen),
+	}
+}
+
+func (publisher *redisEventPublisher) Publish(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return publisher.client.XAdd(ctx, &redis.XAddArgs{
+		Stream:       publisher.stream,
+		MaxLenApprox: publisher.maxlen,
+		Values: map[string]interface{}{
+			"event_id": event.ID.Hex(),
+			"type":     event.Type,
+			"post_id":  event.PostID,
+			"payload":  string(payload),
+		},
+	}).Err()
+}
+
+func (publisher *redisEventPublisher) Close() error {
+	return publisher.client.Close()
+}
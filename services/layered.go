@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resubscribeDelay is how long Run waits before subscribing again when
+// subscribing failed.
+const resubscribeDelay = time.Second
+
+// LayeredRedisService is a RedisService with a bounded in-process LRU in
+// front of another one, so reads it holds skip the round trip to Redis.
+// Delete evicts the key on every instance that Runs, through a pub/sub
+// channel. Set doesn't: other instances keep their copy until the local
+// TTL runs out.
+type LayeredRedisService struct {
+	RedisService
+	local   *lruCache
+	ttl     time.Duration
+	channel string
+}
+
+// NewLayeredRedisService keeps up to size keys of remote in the process,
+// for at most ttl each. Instances sharing remote must use the same channel.
+func NewLayeredRedisService(remote RedisService, size int, ttl time.Duration, channel string) *LayeredRedisService {
+	return &LayeredRedisService{
+		RedisService: remote,
+		local:        newLRUCache(size),
+		ttl:          ttl,
+		channel:      channel,
+	}
+}
+
+func (layered *LayeredRedisService) Set(ctx context.Context, key, message string) error {
+	return layered.SetWithTTL(ctx, key, message, 0)
+}
+
+func (layered *LayeredRedisService) SetWithTTL(ctx context.Context, key, message string, ttl time.Duration) error {
+	if err := layered.RedisService.SetWithTTL(ctx, key, message, ttl); err != nil {
+		layered.local.Delete(key)
+		return err
+	}
+	layered.local.Set(key, message, layered.localTTL(ttl))
+	return nil
+}
+
+// Get answers from the process when it can. A key read from Redis is kept
+// for the local TTL, or until it expires in Redis if that is sooner.
+func (layered *LayeredRedisService) Get(ctx context.Context, key string) (string, error) {
+	if value, ok := layered.local.Get(key); ok {
+		localCacheRequests.WithLabelValues("hit").Inc()
+		return value, nil
+	}
+	localCacheRequests.WithLabelValues("miss").Inc()
+
+	value, ttl, err := layered.RedisService.GetWithTTL(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	layered.local.Set(key, value, layered.localTTL(ttl))
+	return value, nil
+}
+
+// GetWithTTL always asks Redis, the local copy doesn't know the TTL left.
+func (layered *LayeredRedisService) GetWithTTL(ctx context.Context, key string) (string, time.Duration, error) {
+	return layered.RedisService.GetWithTTL(ctx, key)
+}
+
+// localTTL is how long a key with ttl left in Redis is kept locally, 0
+// meaning it doesn't expire there.
+func (layered *LayeredRedisService) localTTL(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < layered.ttl {
+		return ttl
+	}
+	return layered.ttl
+}
+
+// Delete removes key from Redis, then tells the other instances to evict
+// it.
+func (layered *LayeredRedisService) Delete(ctx context.Context, key string) error {
+	layered.local.Delete(key)
+	if err := layered.RedisService.Delete(ctx, key); err != nil {
+		return err
+	}
+	return layered.RedisService.Publish(ctx, layered.channel, key)
+}
+
+// Run evicts the keys other instances delete until ctx is done. Deletes
+// published while it isn't subscribed are missed, so every (re)subscribe
+// starts from an empty local cache.
+func (layered *LayeredRedisService) Run(ctx context.Context) {
+	for {
+		evictions, err := layered.Subscribe(ctx, layered.channel)
+		if err != nil {
+			zap.L().Warn("subscribing to cache evictions failed", zap.Error(err))
+		} else {
+			layered.local.Purge()
+			for key := range evictions {
+				layered.local.Delete(key)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
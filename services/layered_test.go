@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/vinhut/posted/config"
+)
+
+func TestLayeredCache(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remote := NewMemoryRedisService()
+	channel := config.Default().PostCache.InvalidationChannel
+	pod_a := NewLayeredRedisService(remote, 2, time.Minute, channel)
+	pod_b := NewLayeredRedisService(remote, 2, time.Minute, channel)
+
+	// once read, keys are served from the process
+	assert.NoError(t, pod_a.Set(ctx, "post:1", "v1"))
+	value, err := pod_b.Get(ctx, "post:1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	remote.Set(ctx, "post:1", "v2")
+	value, _ = pod_b.Get(ctx, "post:1")
+	assert.Equal(t, "v1", value)
+
+	// the least recently used key makes room
+	remote.Set(ctx, "post:2", "v1")
+	remote.Set(ctx, "post:3", "v1")
+	pod_b.Get(ctx, "post:2")
+	pod_b.Get(ctx, "post:3")
+	value, _ = pod_b.Get(ctx, "post:1")
+	assert.Equal(t, "v2", value)
+
+	// local entries don't outlive a shorter ttl
+	assert.NoError(t, pod_a.SetWithTTL(ctx, "short", "v1", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	_, err = pod_a.Get(ctx, "short")
+	assert.Equal(t, redis.Nil, err)
+
+	// nor do keys read from Redis outlive their ttl there
+	remote.SetWithTTL(ctx, "short", "v1", 50*time.Millisecond)
+	value, err = pod_b.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	time.Sleep(100 * time.Millisecond)
+	_, err = pod_b.Get(ctx, "short")
+	assert.Equal(t, redis.Nil, err)
+
+	go pod_a.Run(ctx)
+	go pod_b.Run(ctx)
+
+	// deletes evict the key on every instance
+	for _, key := range []string{"post:2", "post:3"} {
+		pod_b.Get(ctx, key)
+		assert.NoError(t, pod_a.Delete(ctx, key))
+		assert.Eventually(t, func() bool {
+			_, err := pod_b.Get(ctx, key)
+			return err == redis.Nil
+		}, time.Second, 10*time.Millisecond)
+	}
+
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a bounded in-process cache that evicts the least recently
+// used entry once capacity is reached. Entries also expire after their TTL.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (cache *lruCache) Get(key string) (string, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		cache.removeElement(elem)
+		return "", false
+	}
+	cache.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (cache *lruCache) Set(key, value string, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if elem, ok := cache.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = expires
+		cache.ll.MoveToFront(elem)
+		return
+	}
+
+	elem := cache.ll.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	cache.items[key] = elem
+	if cache.ll.Len() > cache.capacity {
+		cache.removeElement(cache.ll.Back())
+	}
+}
+
+func (cache *lruCache) Delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.items[key]; ok {
+		cache.removeElement(elem)
+	}
+}
+
+// Purge drops every entry.
+func (cache *lruCache) Purge() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.ll.Init()
+	cache.items = make(map[string]*list.Element)
+}
+
+func (cache *lruCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.ll.Len()
+}
+
+func (cache *lruCache) removeElement(elem *list.Element) {
+	cache.ll.Remove(elem)
+	delete(cache.items, elem.Value.(*lruEntry).key)
+}
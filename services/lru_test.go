@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+
+	cache := newLRUCache(2)
+	cache.Set("a", "1", time.Minute)
+	cache.Set("b", "2", time.Minute)
+
+	// reading a makes b the least recently used
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	cache.Set("c", "3", time.Minute)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.Len())
+
+	// setting a key again replaces its value and ttl
+	cache.Set("a", "4", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+
+	cache.Delete("c")
+	_, ok = cache.Get("c")
+	assert.False(t, ok)
+
+	cache.Set("d", "5", time.Minute)
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+
+}
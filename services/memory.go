@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type memoryEntry struct {
+	value   string
+	expires time.Time
+}
+
+// memorySubscriberBuffer is how many messages a subscriber of
+// memoryRedisService may fall behind before it misses some.
+const memorySubscriberBuffer = 64
+
+// memoryRedisService is a RedisService backed by a map. Missing and
+// expired keys answer redis.Nil like a real server.
+type memoryRedisService struct {
+	mu          sync.Mutex
+	entries     map[string]memoryEntry
+	subscribers map[string]map[chan string]bool
+}
+
+func NewMemoryRedisService() RedisService {
+	return &memoryRedisService{
+		entries:     make(map[string]memoryEntry),
+		subscribers: make(map[string]map[chan string]bool),
+	}
+}
+
+func (cache *memoryRedisService) Set(ctx context.Context, key, message string) error {
+	return cache.SetWithTTL(ctx, key, message, 0)
+}
+
+func (cache *memoryRedisService) SetWithTTL(ctx context.Context, key, message string, ttl time.Duration) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry := memoryEntry{value: message}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	cache.entries[key] = entry
+	return nil
+}
+
+func (cache *memoryRedisService) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := cache.GetWithTTL(ctx, key)
+	return value, err
+}
+
+func (cache *memoryRedisService) GetWithTTL(ctx context.Context, key string) (string, time.Duration, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, found := cache.entries[key]
+	if !found {
+		return "", 0, redis.Nil
+	}
+	if entry.expires.IsZero() {
+		return entry.value, 0, nil
+	}
+	ttl := time.Until(entry.expires)
+	if ttl <= 0 {
+		delete(cache.entries, key)
+		return "", 0, redis.Nil
+	}
+	return entry.value, ttl, nil
+}
+
+func (cache *memoryRedisService) Delete(ctx context.Context, key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.entries, key)
+	return nil
+}
+
+func (cache *memoryRedisService) Publish(ctx context.Context, channel, message string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for subscriber := range cache.subscribers[channel] {
+		select {
+		case subscriber <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+func (cache *memoryRedisService) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	subscriber := make(chan string, memorySubscriberBuffer)
+	if cache.subscribers[channel] == nil {
+		cache.subscribers[channel] = make(map[chan string]bool)
+	}
+	cache.subscribers[channel][subscriber] = true
+
+	go func() {
+		<-ctx.Done()
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		delete(cache.subscribers[channel], subscriber)
+		close(subscriber)
+	}()
+	return subscriber, nil
+}
+
+// Scan matches with path.Match, which agrees with Redis globs for keys
+// without slashes.
+func (cache *memoryRedisService) Scan(ctx context.Context, match string, fn func(key string) error) error {
+	cache.mu.Lock()
+	var keys []string
+	now := time.Now()
+	for key, entry := range cache.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			continue
+		}
+		if matched, _ := path.Match(match, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	cache.mu.Unlock()
+
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cache *memoryRedisService) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (cache *memoryRedisService) Close() error {
+	return nil
+}
+
+// FakeUser is an account of fakeAuthService. Its fields are what Check
+// returns as user data.
+type FakeUser struct {
+	Uid        string `json:"uid"`
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	Username   string `json:"username"`
+	Screenname string `json:"screenname"`
+	Avatarurl  string `json:"avatarurl"`
+	Verified   string `json:"verified"`
+	password   string
+}
+
+// fakeAuthService stands in for the auth service. Tokens are handed out by
+// Login and Create or registered up front with NewFakeAuthService.
+type fakeAuthService struct {
+	mu     sync.Mutex
+	tokens map[string]*FakeUser
+}
+
+// NewFakeAuthService accepts the given tokens for their users.
+func NewFakeAuthService(users map[string]FakeUser) AuthService {
+	auth := &fakeAuthService{
+		tokens: make(map[string]*FakeUser),
+	}
+	for token, user := range users {
+		user := user
+		auth.tokens[token] = &user
+	}
+	return auth
+}
+
+func newFakeToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func (auth *fakeAuthService) Login(ctx context.Context, service string, email string, password string) (string, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	for _, user := range auth.tokens {
+		if user.Email == email && user.password == password {
+			token := newFakeToken()
+			auth.tokens[token] = user
+			return token, nil
+		}
+	}
+	return "", ErrUnauthorized
+}
+
+func (auth *fakeAuthService) Check(ctx context.Context, service string, token string) (string, error) {
+	auth.mu.Lock()
+	user, found := auth.tokens[token]
+	auth.mu.Unlock()
+	if !found {
+		return "", ErrUnauthorized
+	}
+	user_data, err := json.Marshal(user)
+	if err != nil {
+		return "", err
+	}
+	return string(user_data), nil
+}
+
+func (auth *fakeAuthService) Update(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Create registers a user named after the local part of email. It can log
+// in right away.
+func (auth *fakeAuthService) Create(ctx context.Context, service string, email string, password string) (bool, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	for _, user := range auth.tokens {
+		if user.Email == email {
+			return false, nil
+		}
+	}
+	name := strings.SplitN(email, "@", 2)[0]
+	auth.tokens[newFakeToken()] = &FakeUser{
+		Uid:        newFakeToken(),
+		Email:      email,
+		Role:       "standard",
+		Username:   name,
+		Screenname: name,
+		Verified:   "false",
+		password:   password,
+	}
+	return true, nil
+}
+
+// Delete revokes a token.
+func (auth *fakeAuthService) Delete(ctx context.Context, token string) (bool, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	if _, found := auth.tokens[token]; !found {
+		return false, nil
+	}
+	delete(auth.tokens, token)
+	return true, nil
+}
+
+func (auth *fakeAuthService) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryServices(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache := NewMemoryRedisService()
+	assert.NoError(t, cache.SetWithTTL(ctx, "short", "value", time.Millisecond))
+	assert.NoError(t, cache.Set(ctx, "long", "value"))
+	time.Sleep(5 * time.Millisecond)
+	_, err := cache.Get(ctx, "short")
+	assert.Equal(t, redis.Nil, err)
+	value, err := cache.Get(ctx, "long")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	_, ttl, _ := cache.GetWithTTL(ctx, "long")
+	assert.Equal(t, time.Duration(0), ttl)
+	cache.SetWithTTL(ctx, "minute", "value", time.Minute)
+	_, ttl, _ = cache.GetWithTTL(ctx, "minute")
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	assert.NoError(t, cache.Delete(ctx, "long"))
+	_, err = cache.Get(ctx, "long")
+	assert.Equal(t, redis.Nil, err)
+
+	cache.Set(ctx, "post:1", "value")
+	cache.Set(ctx, "post:2", "value")
+	cache.Set(ctx, "user:1", "value")
+	var scanned []string
+	assert.NoError(t, cache.Scan(ctx, "post:*", func(key string) error {
+		scanned = append(scanned, key)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"post:1", "post:2"}, scanned)
+
+	subscribe_ctx, cancel := context.WithCancel(ctx)
+	messages, err := cache.Subscribe(subscribe_ctx, "channel")
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Publish(ctx, "channel", "message"))
+	assert.Equal(t, "message", <-messages)
+	cancel()
+	_, open := <-messages
+	assert.False(t, open)
+
+	auth := NewFakeAuthService(nil)
+	created, err := auth.Create(ctx, testService, "carol@example.com", "secret")
+	assert.True(t, created)
+	assert.NoError(t, err)
+	created, _ = auth.Create(ctx, testService, "carol@example.com", "secret")
+	assert.False(t, created)
+
+	_, err = auth.Login(ctx, testService, "carol@example.com", "wrong")
+	assert.Equal(t, ErrUnauthorized, err)
+	token, err := auth.Login(ctx, testService, "carol@example.com", "secret")
+	assert.NoError(t, err)
+
+	user_data, err := auth.Check(ctx, testService, token)
+	assert.NoError(t, err)
+	user := FakeUser{}
+	json.Unmarshal([]byte(user_data), &user)
+	assert.Equal(t, "carol", user.Username)
+
+	revoked, _ := auth.Delete(ctx, token)
+	assert.True(t, revoked)
+	_, err = auth.Check(ctx, testService, token)
+	assert.Equal(t, ErrUnauthorized, err)
+
+}
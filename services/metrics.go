@@ -0,0 +1,27 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	authDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "post_service",
+		Name:      "auth_request_duration_seconds",
+		Help:      "Latency of calls to the auth service.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "auth_request_failures_total",
+		Help:      "Auth service calls that failed, by reason (transport, server_error or circuit_open).",
+	}, []string{"endpoint", "reason"})
+
+	localCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "post_service",
+		Name:      "local_cache_requests_total",
+		Help:      "Reads of the in-process cache in front of Redis, by result (hit or miss).",
+	}, []string{"result"})
+)
@@ -3,33 +3,46 @@ package services
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
-	"os"
+	"github.com/vinhut/posted/config"
+	"time"
 )
 
 type RedisService interface {
-	Set(string, string) error
-	Get(string) (string, error)
-	Delete(string) error
+	Set(context.Context, string, string) error
+	SetWithTTL(context.Context, string, string, time.Duration) error
+	Get(context.Context, string) (string, error)
+	// GetWithTTL also returns how long key has left, 0 when it doesn't
+	// expire.
+	GetWithTTL(context.Context, string) (string, time.Duration, error)
+	Delete(context.Context, string) error
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe delivers the messages published on channel until ctx is
+	// done, then closes the returned channel.
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+	// Scan calls fn with every key matching the glob pattern match. Keys
+	// set or deleted while it runs may or may not be seen.
+	Scan(ctx context.Context, match string, fn func(key string) error) error
+	Ping(context.Context) error
+	Close() error
 }
 
 type redisService struct {
 	client *redis.Client
 }
 
-func NewRedisService() RedisService {
-	redisURL := os.Getenv("REDIS_URL")
+func NewRedisService(cfg config.RedisConfig) RedisService {
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: "", // no password set
-		DB:       0,  // use default DB
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
+	rdb.AddHook(redisTracingHook{})
 	return &redisService{
 		client: rdb,
 	}
 }
 
-func (redisClient *redisService) Set(key, message string) error {
-	ctx := context.Background()
+func (redisClient *redisService) Set(ctx context.Context, key, message string) error {
 	err := redisClient.client.Set(ctx, key, message, 0).Err()
 	if err != nil {
 		return err
@@ -37,9 +50,12 @@ func (redisClient *redisService) Set(key, message string) error {
 	return nil
 }
 
-func (redisClient *redisService) Get(key string) (string, error) {
+func (redisClient *redisService) SetWithTTL(ctx context.Context, key, message string, ttl time.Duration) error {
+	return redisClient.client.Set(ctx, key, message, ttl).Err()
+}
+
+func (redisClient *redisService) Get(ctx context.Context, key string) (string, error) {
 
-	ctx := context.Background()
 	val, err := redisClient.client.Get(ctx, key).Result()
 	if err != nil {
 		return "", err
@@ -47,10 +63,91 @@ func (redisClient *redisService) Get(key string) (string, error) {
 	return val, nil
 }
 
-func (redisClient *redisService) Delete(key string) error {
+func (redisClient *redisService) GetWithTTL(ctx context.Context, key string) (string, time.Duration, error) {
+
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := redisClient.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	// PTTL answers -1 for keys without a ttl
+	ttl := pttl.Val()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return get.Val(), ttl, nil
+}
+
+func (redisClient *redisService) Delete(ctx context.Context, key string) error {
 
-	ctx := context.Background()
 	err := redisClient.client.Del(ctx, key).Err()
 	return err
 
 }
+
+func (redisClient *redisService) Publish(ctx context.Context, channel, message string) error {
+	return redisClient.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe waits for Redis to confirm the subscription, so messages
+// published after it returns are delivered. go-redis reconnects a broken
+// subscription by itself, messages published in between are lost.
+func (redisClient *redisService) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+
+	pubsub := redisClient.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+		received := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-received:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+// scanCount is how many keys redisService.Scan asks for per SCAN call.
+const scanCount = 1000
+
+func (redisClient *redisService) Scan(ctx context.Context, match string, fn func(key string) error) error {
+
+	iter := redisClient.client.Scan(ctx, 0, match, scanCount).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+
+}
+
+func (redisClient *redisService) Ping(ctx context.Context) error {
+	return redisClient.client.Ping(ctx).Err()
+}
+
+func (redisClient *redisService) Close() error {
+	return redisClient.client.Close()
+}
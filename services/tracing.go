@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/vinhut/posted/propagation"
+)
+
+type spanContextKey struct{}
+
+// redisTracingHook wraps every Redis command in a child span.
+type redisTracingHook struct{}
+
+func (redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	span := propagation.StartClientSpan(ctx, "redis "+cmd.Name())
+	if span == nil {
+		return ctx, nil
+	}
+	ext.DBType.Set(span, "redis")
+	return context.WithValue(ctx, spanContextKey{}, span), nil
+}
+
+func (redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if span, ok := ctx.Value(spanContextKey{}).(opentracing.Span); ok {
+		err := cmd.Err()
+		if err == redis.Nil {
+			// a cache miss is not a failure
+			err = nil
+		}
+		propagation.FinishClientSpan(span, err)
+	}
+	return nil
+}
+
+func (redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	span := propagation.StartClientSpan(ctx, "redis pipeline")
+	if span == nil {
+		return ctx, nil
+	}
+	ext.DBType.Set(span, "redis")
+	span.SetTag("redis.commands", len(cmds))
+	return context.WithValue(ctx, spanContextKey{}, span), nil
+}
+
+func (redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if span, ok := ctx.Value(spanContextKey{}).(opentracing.Span); ok {
+		propagation.FinishClientSpan(span, nil)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+	jaegerzap "github.com/uber/jaeger-client-go/log/zap"
+	transport "github.com/uber/jaeger-client-go/transport/zipkin"
+	"github.com/uber/jaeger-client-go/zipkin"
+	jprom "github.com/uber/jaeger-lib/metrics/prometheus"
+	"github.com/vinhut/posted/config"
+	"go.uber.org/zap"
+
+	"fmt"
+	"io"
+)
+
+// initTracer installs the Jaeger tracer as the global tracer. Spans are
+// propagated with B3 headers so traces join up with the other services.
+func initTracer(cfg config.JaegerConfig) (io.Closer, error) {
+
+	var JAEGER_COLLECTOR_ENDPOINT = cfg.CollectorEndpoint
+	zipkinPropagator := zipkin.NewZipkinB3HTTPHeaderPropagator()
+	trsport, err := transport.NewHTTPTransport(
+		JAEGER_COLLECTOR_ENDPOINT,
+		transport.HTTPLogger(jaegerzap.NewLogger(zap.L())),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tracerCfg := jaegercfg.Configuration{
+		ServiceName: SERVICE_NAME,
+		Sampler: &jaegercfg.SamplerConfig{
+			Type:  "const",
+			Param: 1,
+		},
+		Reporter: &jaegercfg.ReporterConfig{
+			LogSpans:          true,
+			CollectorEndpoint: JAEGER_COLLECTOR_ENDPOINT,
+		},
+	}
+	jLogger := jaegerzap.NewLogger(zap.L())
+	jMetricsFactory := jprom.New(jprom.WithRegisterer(prometheus.DefaultRegisterer))
+	return tracerCfg.InitGlobalTracer(
+		SERVICE_NAME,
+		jaegercfg.Logger(jLogger),
+		jaegercfg.Metrics(jMetricsFactory),
+		jaegercfg.Injector(opentracing.HTTPHeaders, zipkinPropagator),
+		jaegercfg.Extractor(opentracing.HTTPHeaders, zipkinPropagator),
+		jaegercfg.ZipkinSharedRPCSpan(true),
+		jaegercfg.Reporter(jaeger.NewRemoteReporter(trsport)),
+	)
+}
+
+// tracingMiddleware starts a server span for every request, joined to the
+// caller's trace when the request carries one. The span is stored in the
+// request context so handlers and the clients they call add child spans.
+func tracingMiddleware(tracer opentracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		parent, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(c.Request.Header))
+		span := tracer.StartSpan(c.Request.Method+" "+route, ext.RPCServerOption(parent))
+		ext.Component.Set(span, "gin")
+		ext.HTTPMethod.Set(span, c.Request.Method)
+		ext.HTTPUrl.Set(span, c.Request.URL.Path)
+		span.SetTag("http.route", route)
+
+		defer func() {
+			if r := recover(); r != nil {
+				ext.HTTPStatusCode.Set(span, 500)
+				ext.Error.Set(span, true)
+				span.LogFields(otlog.String("event", "panic"), otlog.String("message", fmt.Sprint(r)))
+				span.Finish()
+				panic(r)
+			}
+		}()
+
+		c.Request = c.Request.WithContext(opentracing.ContextWithSpan(c.Request.Context(), span))
+		c.Next()
+
+		status := c.Writer.Status()
+		ext.HTTPStatusCode.Set(span, uint16(status))
+		if status >= 500 {
+			ext.Error.Set(span, true)
+		}
+		for _, err := range c.Errors {
+			span.LogFields(otlog.String("event", "error"), otlog.String("message", err.Error()))
+		}
+		span.Finish()
+	}
+}